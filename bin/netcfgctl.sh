@@ -0,0 +1,2 @@
+cd ../src/netcfgctl
+go run client.go main.go output.go "$@"
\ No newline at end of file
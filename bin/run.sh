@@ -1,2 +1,2 @@
 cd ../src
-go run api_server.go datasource.go interface.go
\ No newline at end of file
+go run api_server.go audit.go auth.go boot.go daemon_config.go datasource.go drift.go events.go health.go hotplug.go interface.go listen.go macvlan.go metrics.go naming.go offline.go reconcile.go role.go shutdown.go status.go tunnel.go veth.go vxlan.go "$@"
\ No newline at end of file
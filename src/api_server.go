@@ -1,28 +1,66 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	log "github.com/Sirupsen/logrus"
+	"github.com/evanphx/json-patch"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v2"
 )
 
 var (
-	ErrNameUsed = errors.New("Interface Name alerady exists")
-	ErrDevsUsed = errors.New("Devs has alerady been occupied")
+	ErrNameUsed      = errors.New("Interface Name alerady exists")
+	ErrDevsUsed      = errors.New("Devs has alerady been occupied")
+	ErrNotFound      = errors.New("Interface not found")
+	ErrSource        = errors.New("Unknown config source, should be datasource or system")
+	ErrNameNull      = errors.New("Interface Name can not be empty")
+	ErrDevsNull      = errors.New("Devs or parent does not exist")
+	ErrBondMode      = errors.New("Bond mode should be 0~6")
+	ErrVlanTag       = errors.New("Vlan tag should be 1~4094")
+	ErrPatch         = errors.New("Content-Type should be application/merge-patch+json or application/json-patch+json")
+	ErrMatchMac      = errors.New("MatchMac should be a MAC address like 52:54:00:12:34:56")
+	ErrMatchPci      = errors.New("MatchPci should be a PCI address like 0000:03:00.0")
+	ErrMatchUsed     = errors.New("MatchMac or MatchPci has already been used by another device")
+	ErrMtu           = errors.New("Mtu should be 68~65535")
+	ErrVlanMtu       = errors.New("The Mtu of vlan, macvlan or ipvlan can not be larger than its parent's")
+	ErrBondMtu       = errors.New("Bond members should have the same Mtu as the bond")
+	ErrMac           = errors.New("HardwareAddr should be a MAC address like 52:54:00:12:34:56")
+	ErrAlias         = errors.New("Alias can not be longer than 255 bytes")
+	ErrState         = errors.New("State should be up or down")
+	ErrStp           = errors.New("Bridge's Stp should be on or off")
+	ErrAdminLink     = errors.New("The state of admin interface can not be changed")
+	ErrMacvlanMode   = errors.New("Macvlan's Mode should be bridge, private, vepa or passthru")
+	ErrIpvlanMode    = errors.New("Ipvlan's Mode should be l2, l3 or l3s")
+	ErrVxlanVni      = errors.New("Vxlan's Vni should be 1~16777215")
+	ErrVxlanAddr     = errors.New("Vxlan's Local, Remote and Group should be IP addresses, Remote a unicast one")
+	ErrVxlanGroup    = errors.New("Vxlan's Group should be a multicast address, set with Dev and without Remote")
+	ErrVxlanPort     = errors.New("Vxlan's Port should be 1~65535, 4789 if empty")
+	ErrVxlanLearning = errors.New("Vxlan's Learning should be on or off")
+	ErrTunnelMode    = errors.New("Tunnel's Mode should be gre, gretap, ipip, sit or ip6tnl")
+	ErrTunnelAddr    = errors.New("Tunnel's Remote and Local should be IP addresses of the same family, IPv4 for ipip and sit, IPv6 for ip6tnl")
+	ErrTunnelLocal   = errors.New("Tunnel's Local should be an address of an interface on the host")
+	ErrTunnelRemote  = errors.New("Tunnel's Remote is not reachable from the host")
+	ErrTunnelKey     = errors.New("Tunnel's Key should be 0~4294967295, only for gre and gretap")
+	ErrTunnelTtl     = errors.New("Tunnel's Ttl should be 0~255")
+	ErrTunnelMac     = errors.New("Only gretap tunnels have HardwareAddr")
+	ErrVethPeer      = errors.New("Veth's Peer can not be empty")
+	ErrVethNetns     = errors.New("Veth's peer can be moved into PeerNetns, a name under /var/run/netns, or the namespace of PeerPid, not both")
 )
 
-func init() {
-	log.SetFormatter(&log.JSONFormatter{})
-	log.SetOutput(os.Stdout)
-	log.SetLevel(log.InfoLevel)
-}
-
 type ResponseMessage struct {
 	Result  interface{}    `json:"result,omitempty"`
 	Status  bool           `json:"status"`
@@ -31,37 +69,147 @@ type ResponseMessage struct {
 }
 
 func main() {
+	if len(os.Args) > 1 && isOfflineCommand(os.Args[1]) {
+		os.Exit(RunOffline(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	c, printConfig, err := LoadDaemonConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal("Load daemon config: ", err)
+	}
+	if printConfig {
+		data, _ := yaml.Marshal(c)
+		fmt.Print(string(data))
+		return
+	}
+	applyDaemonConfig(c)
+
+	if err := OpenDataSource(c.DataSource); err != nil {
+		log.Fatal("Open data source: ", err)
+	}
+	if c.BootApply {
+		if err := BootApply(c.duration(c.BootWaitDevices)); err != nil {
+			log.WithError(err).Error("启动时应用网络配置失败")
+		}
+	}
+	auths, roles, err := LoadAccess(c)
+	if err != nil {
+		log.Fatal("Load authenticators and roles: ", err)
+	}
+
 	router := httprouter.New()
 	router.GET("/network/init", initNetwork)
 	router.GET("/network/config", config)
+	router.PUT("/network/config", configReplace)
+	router.PATCH("/network/config", configPatch)
 	router.GET("/network/apply", apply)
-
+	router.GET("/network/drift", driftGet)
+	router.GET("/network/status", statusList)
+	router.GET("/network/status/:Name", statusGet)
+	router.Handler("GET", "/metrics", promhttp.Handler())
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+	router.GET("/network/events", eventStream)
+	router.GET("/network/reconcile", reconcileGet)
+	router.PUT("/network/reconcile", reconcileSet)
+	router.POST("/network/reconcile/pause", reconcilePause)
+	router.POST("/network/reconcile/resume", reconcileResume)
+	router.GET("/network/audit", auditList)
+	router.POST("/network/link/:Name/up", linkUp)
+	router.POST("/network/link/:Name/down", linkDown)
+
+	router.GET("/network/device", deviceList)
+	router.GET("/network/device/:Name", deviceGet)
+
+	router.GET("/network/bond", bondList)
+	router.GET("/network/bond/:Name", bondGet)
 	router.POST("/network/bond/", bondAdd) // slave只可以从有的里面去
 	router.DELETE("/network/bond/:Name", bondDel) // todo 没有的name del 显示 失败
 	router.PUT("/network/bond", bondUpdate) // todo 同上
 
+	router.GET("/network/bridge", briList)
+	router.GET("/network/bridge/:Name", briGet)
 	router.POST("/network/bridge", briAdd)
 	router.DELETE("/network/bridge/:Name", briDel)
 	router.PUT("/network/bridge", briUpdate)
 
+	router.GET("/network/vlan", vlanList)
+	router.GET("/network/vlan/:Name", vlanGet)
 	router.POST("/network/vlan", vlanAdd)
 	router.DELETE("/network/vlan/:Name", vlanDel)
 	router.PUT("/network/vlan", vlanUpdate)
-
+	router.GET("/network/macvlan", macvlanList)
+	router.GET("/network/macvlan/:Name", macvlanGet)
+	router.POST("/network/macvlan", macvlanAdd)
+	router.DELETE("/network/macvlan/:Name", macvlanDel)
+	router.PUT("/network/macvlan", macvlanUpdate)
+	router.GET("/network/ipvlan", ipvlanList)
+	router.GET("/network/ipvlan/:Name", ipvlanGet)
+	router.POST("/network/ipvlan", ipvlanAdd)
+	router.DELETE("/network/ipvlan/:Name", ipvlanDel)
+	router.PUT("/network/ipvlan", ipvlanUpdate)
+	router.GET("/network/vxlan", vxlanList)
+	router.GET("/network/vxlan/:Name", vxlanGet)
+	router.POST("/network/vxlan", vxlanAdd)
+	router.DELETE("/network/vxlan/:Name", vxlanDel)
+	router.PUT("/network/vxlan", vxlanUpdate)
+	router.GET("/network/tunnel", tunnelList)
+	router.GET("/network/tunnel/:Name", tunnelGet)
+	router.POST("/network/tunnel", tunnelAdd)
+	router.DELETE("/network/tunnel/:Name", tunnelDel)
+	router.PUT("/network/tunnel", tunnelUpdate)
+	router.GET("/network/veth", vethList)
+	router.GET("/network/veth/:Name", vethGet)
+	router.POST("/network/veth", vethAdd)
+	router.DELETE("/network/veth/:Name", vethDel)
+	router.PUT("/network/veth", vethUpdate)
+
+	router.GET("/network/Ip", ipList)
+	router.GET("/network/Ip/:Name", ipGet)
 	router.POST("/network/Ip", ipAdd)
 	router.DELETE("/network/Ip", ipDel)
 
-	log.Info("服务启动")
-	err := http.ListenAndServe(":9090", router) //设置监听的端口
+	go WatchNetlink()
+	go reconciler.Run()
+	go WatchHotplug()
+
+	var tlsConfig *tls.Config
+	var reloader *CertReloader
+	if c.TLSCert != "" {
+		if reloader, err = NewCertReloader(c.TLSCert, c.TLSKey); err != nil {
+			log.Fatal("Load certificate: ", err)
+		}
+		if tlsConfig, err = NewTLSConfig(reloader, c.ClientCA); err != nil {
+			log.Fatal("TLS config: ", err)
+		}
+	}
+
+	listeners, err := Listen(c.Listen, c.socketMode(), c.AdminOnly) //设置监听的地址
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatal("Listen: ", err)
 	}
+
+	log.Info("服务启动")
+	authHandler := NewAuthHandler(NewAuditHandler(router), auths, roles)
+	server := &http.Server{Handler: authHandler}
+	go func() {
+		if err := Serve(server, listeners, tlsConfig); err != http.ErrServerClosed {
+			log.Fatal("Serve: ", err)
+		}
+	}()
+	HandleSignals(server, authHandler, reloader, os.Args[1:])
+	log.Info("服务关闭")
 }
 
 func initNetwork(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
 	log.Info("初始化网络")
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
+	applyLock.Lock()
+	defer applyLock.Unlock()
 	if err := breakNetwork(); err != nil {
 		rm = ResponseMessage{Status: false, Message: "初始化网络配置失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
@@ -85,16 +233,53 @@ func config(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
 	resp.Write(ret)
 }
 
+func configReplace(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "网络配置替换失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := ConfigReplace(userConfig); err != nil {
+		rm = ResponseMessage{Status: false, Message: "网络配置替换失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Config", userConfig).Info("替换网络配置")
+		configMutations.WithLabelValues("config").Inc()
+		rm = ResponseMessage{Result: userConfig, Status: true, Message: "网络配置替换成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func configPatch(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if userConfig, err := ConfigPatch(req.Header.Get("Content-Type"), body); err == ErrPatch {
+		rm = ResponseMessage{Status: false, Message: "网络配置修改失败." + err.Error(), Code: http.StatusUnsupportedMediaType}
+	} else if err != nil {
+		rm = ResponseMessage{Status: false, Message: "网络配置修改失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Patch", string(body)).Info("修改网络配置")
+		configMutations.WithLabelValues("config").Inc()
+		rm = ResponseMessage{Result: userConfig, Status: true, Message: "网络配置修改成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
 func apply(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
 	log.Info("应用网络配置")
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
+	applyLock.Lock()
+	defer applyLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "获取数据库配置失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else if err := Apply(userConfig); err != nil {
 		rm = ResponseMessage{Status: false, Message: "应用网络配置失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
+		markLastGood(userConfig)
 		sysConfig, _ := GetConfigFromSys()
 		rm = ResponseMessage{Result: sysConfig, Status: true, Message: "应用网络配置成功", Code: http.StatusOK}
 	}
@@ -103,16 +288,272 @@ func apply(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
 	resp.Write(ret)
 }
 
+func deviceList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Device失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Devices, Status: true, Message: "获取Device成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func deviceGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Device失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if device, ok := findDevice(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Device失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: device, Status: true, Message: "获取Device成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func bondList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Bond失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Bonds, Status: true, Message: "获取Bond成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func bondGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Bond失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if bond, ok := findBond(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Bond失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: bond, Status: true, Message: "获取Bond成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func driftGet(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	drifts, err := GetDrift()
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取配置偏差失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: drifts, Status: true, Message: "获取配置偏差成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+// the daemon is alive as long as it can answer
+func healthz(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	resp.Header().Set("Content-Type", "application/json")
+	rm := ResponseMessage{Status: true, Message: "alive", Code: http.StatusOK}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+// supervisors only look at the http status, so it is set to 503 when not ready
+func readyz(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	if checks, ready := Readiness(); ready {
+		rm = ResponseMessage{Result: checks, Status: true, Message: "ready", Code: http.StatusOK}
+	} else {
+		rm = ResponseMessage{Result: checks, Status: false, Message: "not ready", Code: http.StatusServiceUnavailable}
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func statusList(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	status, err := GetStatusFromSys()
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取接口状态失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: status, Status: true, Message: "获取接口状态成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func statusGet(resp http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	status, err := GetStatusFromSys()
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取接口状态失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Status: false, Message: "获取接口状态失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+		for _, s := range status {
+			if s.Name == name {
+				rm = ResponseMessage{Result: s, Status: true, Message: "获取接口状态成功", Code: http.StatusOK}
+			}
+		}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+// server-sent events, one event per message: "event: link.added\ndata: {...}\n\n"
+func eventStream(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		resp.Header().Set("Content-Type", "application/json")
+		rm := ResponseMessage{Status: false, Message: "订阅事件失败.Streaming unsupported", Code: http.StatusInternalServerError}
+		ret, _ := json.MarshalIndent(rm, "", "\t")
+		resp.Write(ret)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	ch := events.Subscribe()
+	defer events.Unsubscribe(ch)
+	flusher.Flush()
+
+	// keep the connection alive through proxies
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case e := <-ch:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-ping.C:
+			fmt.Fprint(resp, ": ping\n\n")
+		case <-req.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func reconcileGet(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	resp.Header().Set("Content-Type", "application/json")
+	rm := ResponseMessage{Result: reconciler.Status(), Status: true, Message: "获取自动纠偏状态成功", Code: http.StatusOK}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func reconcileSet(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	r, err := getReconcileJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "自动纠偏设置失败." + err.Error(), Code: http.StatusBadRequest}
+	} else {
+		// the settings not given are kept
+		enabled := reconciler.Status().Enabled
+		if r.Enabled != nil {
+			enabled = *r.Enabled
+		}
+		reconciler.Set(enabled, r.interval, r.maxBackoff)
+		log.WithField("Reconcile", r).Info("设置自动纠偏")
+		rm = ResponseMessage{Result: reconciler.Status(), Status: true, Message: "自动纠偏设置成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func linkUp(resp http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := SetLinkState(name, UP); err == ErrNotFound {
+		rm = ResponseMessage{Status: false, Message: "接口启用失败." + err.Error(), Code: http.StatusNotFound}
+	} else if err != nil {
+		rm = ResponseMessage{Status: false, Message: "接口启用失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Name", name).Info("启用接口")
+		rm = ResponseMessage{Status: true, Message: "接口启用成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func linkDown(resp http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := SetLinkState(name, DOWN); err == ErrNotFound {
+		rm = ResponseMessage{Status: false, Message: "接口停用失败." + err.Error(), Code: http.StatusNotFound}
+	} else if err != nil {
+		rm = ResponseMessage{Status: false, Message: "接口停用失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Name", name).Info("停用接口")
+		rm = ResponseMessage{Status: true, Message: "接口停用成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func reconcilePause(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	resp.Header().Set("Content-Type", "application/json")
+	reconciler.Pause(true)
+	log.Info("暂停自动纠偏")
+	rm := ResponseMessage{Result: reconciler.Status(), Status: true, Message: "暂停自动纠偏成功", Code: http.StatusOK}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func reconcileResume(resp http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	resp.Header().Set("Content-Type", "application/json")
+	reconciler.Pause(false)
+	log.Info("恢复自动纠偏")
+	rm := ResponseMessage{Result: reconciler.Status(), Status: true, Message: "恢复自动纠偏成功", Code: http.StatusOK}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+// GET /network/audit?since=2006-01-02T15:04:05Z&until=...&resource=bond&principal=alice&limit=100
+func auditList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	filter, err := getAuditFilterParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取审计日志失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if records, err := auditLog.Query(filter); err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取审计日志失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: records, Status: true, Message: "获取审计日志成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
 func bondAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
 	bond, err := getBondJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bond添加失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := BondAdd(bond.Name, bond.Mode, bond.Devs); err != nil {
+	} else if err := BondAdd(bond); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bond添加失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Bond", Bond{Name: bond.Name, Mode: bond.Mode, Devs: bond.Devs}).Info("添加Bond")
+		log.WithField("Bond", bondSettings(bond)).Info("添加Bond")
+		configMutations.WithLabelValues(BOND).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bond添加成功", Code: http.StatusCreated}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -125,10 +566,11 @@ func bondUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Param
 	bond, err := getBondJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bond更新失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := BondUpdate(bond.Name, int(bond.Mode), bond.Devs); err != nil {
+	} else if err := BondUpdate(bond); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bond更新失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Bond", Bond{Name: bond.Name, Mode: bond.Mode, Devs: bond.Devs}).Info("更新Bond")
+		log.WithField("Bond", bondSettings(bond)).Info("更新Bond")
+		configMutations.WithLabelValues(BOND).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bond更新成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -145,22 +587,53 @@ func bondDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params)
 		rm = ResponseMessage{Status: false, Message: "Bond删除失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
 		log.Info("删除Bond:" + name)
+		configMutations.WithLabelValues(BOND).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bond删除成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
 	resp.Write(ret)
 }
 
+func briList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Bridge失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Bridges, Status: true, Message: "获取Bridge成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func briGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Bridge失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if bri, ok := findBridge(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Bridge失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: bri, Status: true, Message: "获取Bridge成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
 func briAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
 	bri, err := getBridgeJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bridge添加失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := BridgeAdd(bri.Name, bri.Devs, bri.Mtu); err != nil {
+	} else if err := BridgeAdd(bri); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bridge添加失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Bridge", Bridge{Name: bri.Name, Devs: bri.Devs, Mtu: bri.Mtu}).Info("添加Bridge")
+		log.WithField("Bridge", bridgeSettings(bri)).Info("添加Bridge")
+		configMutations.WithLabelValues(BRIDGE).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bridge添加成功", Code: http.StatusCreated}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -173,10 +646,11 @@ func briUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params
 	bri, err := getBridgeJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bridge更新失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := BridgeUpdate(bri.Name, bri.Devs, bri.Mtu); err != nil {
+	} else if err := BridgeUpdate(bri); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Bridge更新失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Bridge", Bridge{Name: bri.Name, Devs: bri.Devs, Mtu: bri.Mtu}).Info("更新Bridge")
+		log.WithField("Bridge", bridgeSettings(bri)).Info("更新Bridge")
+		configMutations.WithLabelValues(BRIDGE).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bridge更新成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -194,22 +668,53 @@ func briDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		rm = ResponseMessage{Status: false, Message: "Bridge删除失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
 		log.Info("删除Bridge:" + name)
+		configMutations.WithLabelValues(BRIDGE).Inc()
 		rm = ResponseMessage{Status: true, Message: "Bridge删除成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
 	resp.Write(ret)
 }
 
+func vlanList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Vlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Vlans, Status: true, Message: "获取Vlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vlanGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Vlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if v, ok := findVlan(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Vlan失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: v, Status: true, Message: "获取Vlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
 func vlanAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
 	v, err := getVlanJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Vlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := VlanAdd(v.Name, v.Tag, v.Parent); err != nil {
+	} else if err := VlanAdd(v); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Vlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Vlan", Vlan{Name: v.Name, Parent: v.Parent, Tag: v.Tag}).Info("添加Vlan")
+		log.WithField("Vlan", vlanSettings(v)).Info("添加Vlan")
+		configMutations.WithLabelValues(VLAN).Inc()
 		rm = ResponseMessage{Status: true, Message: "Vlan添加成功", Code: http.StatusCreated}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -222,10 +727,11 @@ func vlanUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Param
 	v, err := getVlanJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "Vlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := VlanUpdate(v.Name, v.Tag, v.Parent); err != nil {
+	} else if err := VlanUpdate(v); err != nil {
 		rm = ResponseMessage{Status: false, Message: "Vlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
-		log.WithField("Vlan", Vlan{Name: v.Name, Parent: v.Parent, Tag: v.Tag}).Info("更新Vlan")
+		log.WithField("Vlan", vlanSettings(v)).Info("更新Vlan")
+		configMutations.WithLabelValues(VLAN).Inc()
 		rm = ResponseMessage{Status: true, Message: "Vlan更新成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -242,22 +748,55 @@ func vlanDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params)
 		rm = ResponseMessage{Status: false, Message: "Vlan删除失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
 		log.Info("删除Vlan:" + name)
+		configMutations.WithLabelValues(VLAN).Inc()
 		rm = ResponseMessage{Status: true, Message: "Vlan删除成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
 	resp.Write(ret)
 }
 
+func ipList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取IP失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: getIPs(userConfig), Status: true, Message: "获取IP成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取IP失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if i, ok := findIP(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取IP失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: i, Status: true, Message: "获取IP成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
 func ipAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var rm ResponseMessage
 	resp.Header().Set("Content-Type", "application/json")
 	i, err := getIPJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "IP添加失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := AssignIP(i.Name, i.Ip); err != nil {
+	} else if err := AssignIP(i.Name, i.Ip); err == ErrNotFound {
+		rm = ResponseMessage{Status: false, Message: "IP添加失败." + err.Error(), Code: http.StatusNotFound}
+	} else if err != nil {
 		rm = ResponseMessage{Status: false, Message: "IP添加失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
 		log.WithField("IP", i.Ip).Info(i.Name + "添加IP")
+		configMutations.WithLabelValues("ip").Inc()
 		rm = ResponseMessage{Status: true, Message: "IP添加成功", Code: http.StatusCreated}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -270,10 +809,13 @@ func ipDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	i, err := getIPJSONParam(req)
 	if err != nil {
 		rm = ResponseMessage{Status: false, Message: "IP删除失败." + err.Error(), Code: http.StatusInternalServerError}
-	} else if err := DelIP(i.Name, i.Ip[0]); err != nil {
+	} else if err := DelIP(i.Name, i.Ip[0]); err == ErrNotFound {
+		rm = ResponseMessage{Status: false, Message: "IP删除失败." + err.Error(), Code: http.StatusNotFound}
+	} else if err != nil {
 		rm = ResponseMessage{Status: false, Message: "IPk删除失败." + err.Error(), Code: http.StatusInternalServerError}
 	} else {
 		log.Info(i.Name + "删除IP " + i.Ip[0])
+		configMutations.WithLabelValues("ip").Inc()
 		rm = ResponseMessage{Status: true, Message: "IP删除成功", Code: http.StatusOK}
 	}
 	ret, _ := json.MarshalIndent(rm, "", "\t")
@@ -283,7 +825,7 @@ func ipDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 ////////////////////////////////////////////////////////////////////
 // get config form system
 func GetConfigFromSys() (Config, error) {
-	var config Config
+	config := Config{HostId: getHostId()}
 	links, err := netlink.LinkList()
 	if err != nil {
 		log.WithError(err).Error("Get link list fail")
@@ -300,10 +842,22 @@ func GetConfigFromSys() (Config, error) {
 	return config, nil
 }
 
+// get config according to the request's source param, default is database
+func getConfigBySource(req *http.Request) (Config, error) {
+	switch req.URL.Query().Get("source") {
+	case "", "datasource":
+		return GetConfigFromDs()
+	case "system":
+		return GetConfigFromSys()
+	}
+	return Config{}, ErrSource
+}
+
 //get config from database
 func GetConfigFromDs() (Config, error) {
 	var config Config
-	err := json.Unmarshal(([]byte)(DataSource["network"]), &config)
+	data, _ := getDataSource("network")
+	err := json.Unmarshal([]byte(data), &config)
 	if err != nil {
 		log.WithError(err).Error("Json unmarshall fail")
 		return Config{}, err
@@ -312,53 +866,130 @@ func GetConfigFromDs() (Config, error) {
 }
 
 // below manipulate database's data
-func BridgeAdd(name string, dev []string, mtu int) error {
-	// 要根据数据源里存的配置的进行校验 而不是从系统中取到的配置
-	userConfig, err := GetConfigFromDs()
-	if err != nil {
-		log.WithError(err).Error("Get config from database failed")
-		return err
-	}
+// replace the whole config, all the changes are written to database at once
+func ConfigReplace(config Config) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return replaceConfig(config)
+}
 
-	if err := validate(name, dev, userConfig); err != nil {
+// hold configLock when calling
+func replaceConfig(config Config) error {
+	if err := validateConfig(config); err != nil {
 		log.WithError(err).Error("Validate fail")
 		return err
 	}
 
-	userConfig.Bridges = append(userConfig.Bridges, Bridge{Name: name, Devs: dev, Mtu: mtu})
-
-	if err := PutToDataSource(userConfig); err != nil {
+	if err := PutToDataSource(config); err != nil {
 		log.WithError(err).Error("Put data to database fail")
 		return err
 	}
 	return nil
 }
 
-func BridgeUpdate(name string, dev []string, mtu int) error { // can not modify Name
-	if err := BridgeDel(name); err != nil {
-		log.WithError(err).Error("Bond " + name + " del fail")
-		return err
+// patch the config with RFC 7386 merge patch or RFC 6902 json patch according to the content type
+func ConfigPatch(contentType string, patch []byte) (Config, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return Config{}, err
 	}
-	if err := BridgeAdd(name, dev, mtu); err != nil {
-		log.WithError(err).Error("Bond " + name + " add fail")
+
+	doc, err := json.Marshal(userConfig)
+	if err != nil {
+		log.WithError(err).Error("Convert config to json failed")
+		return Config{}, err
+	}
+
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "application/merge-patch+json":
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	case "application/json-patch+json":
+		var p jsonpatch.Patch
+		if p, err = jsonpatch.DecodePatch(patch); err == nil {
+			doc, err = p.Apply(doc)
+		}
+	default:
+		return Config{}, ErrPatch
+	}
+	if err != nil {
+		log.WithError(err).Error("Patch config failed")
+		return Config{}, err
+	}
+
+	var patched Config
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		log.WithError(err).Error("Json unmarshall fail")
+		return Config{}, err
+	}
+
+	if err := replaceConfig(patched); err != nil {
+		return Config{}, err
+	}
+	return patched, nil
+}
+
+func BridgeAdd(bri Bridge) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	// 要根据数据源里存的配置的进行校验 而不是从系统中取到的配置
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertBridge(bridgeSettings(bri), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
 		return err
 	}
 	return nil
 }
 
-func BridgeDel(name string) error {
+// the new bridge is validated against the config without the old one, nothing is stored if it is invalid
+func BridgeUpdate(bri Bridge) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	for i, bri := range userConfig.Bridges {
-		if bri.Name == name {
-			userConfig.Bridges = append(userConfig.Bridges[:i], userConfig.Bridges[i+1:]...)
-		}
+	// the IPs are set by the IP API, keep them
+	old, _ := findBridge(bri.Name, userConfig)
+	bri = bridgeSettings(bri)
+	bri.IpNets = old.IpNets
+	removeBridge(bri.Name, &userConfig)
+	if err := insertBridge(bri, &userConfig); err != nil {
+		log.WithError(err).Error("Bridge " + bri.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func BridgeDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
 	}
 
+	removeBridge(name, &userConfig)
+
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
 		return err
@@ -366,6 +997,33 @@ func BridgeDel(name string) error {
 	return nil
 }
 
+// validate and add the bridge to userConfig, shared by add and update
+func insertBridge(bri Bridge, userConfig *Config) error {
+	if err := validate(bri.Name, bri.Devs, *userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	if !validStp(bri.Stp) {
+		return ErrStp
+	}
+
+	userConfig.Bridges = append(userConfig.Bridges, bri)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeBridge(name string, userConfig *Config) {
+	for i, bri := range userConfig.Bridges {
+		if bri.Name == name {
+			userConfig.Bridges = append(userConfig.Bridges[:i], userConfig.Bridges[i+1:]...)
+			break
+		}
+	}
+}
+
 /*
 BOND_MODE_BALANCE_RR     = iota(0)
 BOND_MODE_ACTIVE_BACKUP
@@ -376,20 +1034,19 @@ BOND_MODE_BALANCE_TLB
 BOND_MODE_BALANCE_ALB
 BOND_MODE_UNKNOWN
 */
-func BondAdd(name string, mode int, dev []string) error {
+func BondAdd(bond Bond) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	if err := validate(name, dev, userConfig); err != nil {
-		log.WithError(err).Error("Validate fail")
+	if err := insertBond(bondSettings(bond), &userConfig); err != nil {
 		return err
 	}
 
-	userConfig.Bonds = append(userConfig.Bonds, Bond{Name: name, Mode: mode, Devs: dev})
-
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
 		return err
@@ -398,16 +1055,41 @@ func BondAdd(name string, mode int, dev []string) error {
 }
 
 func BondDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	for i, bri := range userConfig.Bonds {
-		if bri.Name == name {
-			userConfig.Bonds = append(userConfig.Bonds[:i], userConfig.Bonds[i+1:]...)
-		}
+	removeBond(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new bond is validated against the config without the old one, nothing is stored if it is invalid
+func BondUpdate(bond Bond) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findBond(bond.Name, userConfig)
+	bond = bondSettings(bond)
+	bond.IpNets = old.IpNets
+	removeBond(bond.Name, &userConfig)
+	if err := insertBond(bond, &userConfig); err != nil {
+		log.WithError(err).Error("Bond " + bond.Name + " update fail")
+		return err
 	}
 
 	if err := PutToDataSource(userConfig); err != nil {
@@ -417,32 +1099,43 @@ func BondDel(name string) error {
 	return nil
 }
 
-func BondUpdate(name string, mode int, dev []string) error { // can not modify Name
-	if err := BondDel(name); err != nil {
-		log.WithError(err).Error("Bond " + name + " del fail")
+// validate and add the bond to userConfig, shared by add and update
+func insertBond(bond Bond, userConfig *Config) error {
+	if err := validate(bond.Name, bond.Devs, *userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
 		return err
 	}
-	if err := BondAdd(name, mode, dev); err != nil {
-		log.WithError(err).Error("Bond " + name + " add fail")
+
+	userConfig.Bonds = append(userConfig.Bonds, bond)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
 		return err
 	}
 	return nil
 }
 
-func VlanAdd(name string, tag int, parent string) error {
+func removeBond(name string, userConfig *Config) {
+	for i, b := range userConfig.Bonds {
+		if b.Name == name {
+			userConfig.Bonds = append(userConfig.Bonds[:i], userConfig.Bonds[i+1:]...)
+			break
+		}
+	}
+}
+
+func VlanAdd(v Vlan) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	if isLinkAlreadyExists(name, userConfig) {
-		log.WithError(ErrNameUsed).Error("Name:" + name)
-		return ErrNameUsed
+	if err := insertVlan(vlanSettings(v), &userConfig); err != nil {
+		return err
 	}
 
-	userConfig.Vlans = append(userConfig.Vlans, Vlan{Name: name, Tag: tag, Parent: parent})
-
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
 		return err
@@ -450,30 +1143,43 @@ func VlanAdd(name string, tag int, parent string) error {
 	return nil
 }
 
-func VlanUpdate(name string, tag int, parent string) error { // can not modify Name
-	if err := VlanDel(name); err != nil {
-		log.WithError(err).Error("Vlan " + name + " del fail")
+// the new vlan is validated against the config without the old one, nothing is stored if it is invalid
+func VlanUpdate(v Vlan) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
-	if err := VlanAdd(name, tag, parent); err != nil {
-		log.WithError(err).Error("Vlan " + name + " add fail")
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findVlan(v.Name, userConfig)
+	v = vlanSettings(v)
+	v.IpNets = old.IpNets
+	removeVlan(v.Name, &userConfig)
+	if err := insertVlan(v, &userConfig); err != nil {
+		log.WithError(err).Error("Vlan " + v.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
 		return err
 	}
 	return nil
 }
 
 func VlanDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	for i, v := range userConfig.Vlans {
-		if v.Name == name {
-			userConfig.Vlans = append(userConfig.Vlans[:i], userConfig.Vlans[i+1:]...)
-		}
-	}
+	removeVlan(name, &userConfig)
 
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
@@ -482,7 +1188,33 @@ func VlanDel(name string) error {
 	return nil
 }
 
+// validate and add the vlan to userConfig, shared by add and update
+func insertVlan(v Vlan, userConfig *Config) error {
+	if isLinkAlreadyExists(v.Name, *userConfig) {
+		log.WithError(ErrNameUsed).Error("Name:" + v.Name)
+		return ErrNameUsed
+	}
+
+	userConfig.Vlans = append(userConfig.Vlans, v)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeVlan(name string, userConfig *Config) {
+	for i, v := range userConfig.Vlans {
+		if v.Name == name {
+			userConfig.Vlans = append(userConfig.Vlans[:i], userConfig.Vlans[i+1:]...)
+			break
+		}
+	}
+}
+
 func AssignIP(name string, ipNet []string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	for _, ips := range ipNet {
 		_, err := netlink.ParseAddr(ips)
 		if err != nil {
@@ -497,19 +1229,12 @@ func AssignIP(name string, ipNet []string) error {
 		return err
 	}
 
-	// assign IP to devices
-	for i, d := range userConfig.Devices {
-		if d.Name == name {
-			userConfig.Devices[i].IpNets = append(userConfig.Devices[i].IpNets, ipNet...)
-		}
-	}
-
-	// assign IP to bonds
-	for i, b := range userConfig.Bonds {
-		if b.Name == name {
-			userConfig.Bonds[i].IpNets = append(userConfig.Bonds[i].IpNets, ipNet...)
-		}
+	ipNets := ipNetsOf(name, &userConfig)
+	if ipNets == nil {
+		log.WithError(ErrNotFound).Error("Name:" + name)
+		return ErrNotFound
 	}
+	*ipNets = append(*ipNets, ipNet...)
 
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
@@ -519,34 +1244,236 @@ func AssignIP(name string, ipNet []string) error {
 }
 
 func DelIP(name string, ipNet string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	userConfig, err := GetConfigFromDs()
 	if err != nil {
 		log.WithError(err).Error("Get config from database failed")
 		return err
 	}
 
-	//del devices's IP
-	for i, d := range userConfig.Devices {
+	ipNets := ipNetsOf(name, &userConfig)
+	if ipNets == nil {
+		log.WithError(ErrNotFound).Error("Name:" + name)
+		return ErrNotFound
+	}
+	for j, ipnet := range *ipNets {
+		if ipnet == ipNet {
+			*ipNets = append((*ipNets)[:j], (*ipNets)[j+1:]...)
+			break
+		}
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the IPs of the interface name in config, the same interfaces as getIPs, nil if there is no such interface
+func ipNetsOf(name string, config *Config) *[]string {
+	for i := range config.Devices {
+		if config.Devices[i].Name == name {
+			return &config.Devices[i].IpNets
+		}
+	}
+	for i := range config.Bonds {
+		if config.Bonds[i].Name == name {
+			return &config.Bonds[i].IpNets
+		}
+	}
+	for i := range config.Vlans {
+		if config.Vlans[i].Name == name {
+			return &config.Vlans[i].IpNets
+		}
+	}
+	for i := range config.Bridges {
+		if config.Bridges[i].Name == name {
+			return &config.Bridges[i].IpNets
+		}
+	}
+	for i := range config.Vxlans {
+		if config.Vxlans[i].Name == name {
+			return &config.Vxlans[i].IpNets
+		}
+	}
+	for i := range config.Tunnels {
+		if config.Tunnels[i].Name == name {
+			return &config.Tunnels[i].IpNets
+		}
+	}
+	for i := range config.Veths {
+		if config.Veths[i].Name == name {
+			return &config.Veths[i].IpNets
+		}
+		if peerInHost(config.Veths[i]) && config.Veths[i].Peer == name {
+			return &config.Veths[i].PeerIpNets
+		}
+	}
+	for i := range config.Macvlans {
+		if config.Macvlans[i].Name == name {
+			return &config.Macvlans[i].IpNets
+		}
+	}
+	for i := range config.Ipvlans {
+		if config.Ipvlans[i].Name == name {
+			return &config.Ipvlans[i].IpNets
+		}
+	}
+	return nil
+}
+
+func findDevice(name string, config Config) (Device, bool) {
+	for _, d := range config.Devices {
 		if d.Name == name {
-			for j, ipnet := range userConfig.Devices[i].IpNets {
-				if ipnet == ipNet {
-					userConfig.Devices[i].IpNets = append(userConfig.Devices[i].IpNets[:j], userConfig.Devices[i].IpNets[j+1:]...)
-				}
-			}
+			return d, true
 		}
 	}
+	return Device{}, false
+}
 
-	//del bonds's IP
-	for i, b := range userConfig.Bonds {
+func findBond(name string, config Config) (Bond, bool) {
+	for _, b := range config.Bonds {
 		if b.Name == name {
-			for j, ipnet := range userConfig.Bonds[i].IpNets {
-				if ipnet == ipNet {
-					userConfig.Bonds[i].IpNets = append(userConfig.Bonds[i].IpNets[:j], userConfig.Bonds[i].IpNets[j+1:]...)
-				}
-			}
+			return b, true
 		}
 	}
+	return Bond{}, false
+}
 
+func findBridge(name string, config Config) (Bridge, bool) {
+	for _, br := range config.Bridges {
+		if br.Name == name {
+			return br, true
+		}
+	}
+	return Bridge{}, false
+}
+
+func findVlan(name string, config Config) (Vlan, bool) {
+	for _, v := range config.Vlans {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Vlan{}, false
+}
+
+// IPs of every interface, eg: {Name:eth0, Ip:[1.1.1.1/24]}
+func getIPs(config Config) []ipParam {
+	var ips []ipParam
+	for _, d := range config.Devices {
+		ips = append(ips, ipParam{d.Name, d.IpNets})
+	}
+	for _, b := range config.Bonds {
+		ips = append(ips, ipParam{b.Name, b.IpNets})
+	}
+	for _, v := range config.Vlans {
+		ips = append(ips, ipParam{v.Name, v.IpNets})
+	}
+	for _, br := range config.Bridges {
+		ips = append(ips, ipParam{br.Name, br.IpNets})
+	}
+	for _, x := range config.Vxlans {
+		ips = append(ips, ipParam{x.Name, x.IpNets})
+	}
+	for _, tun := range config.Tunnels {
+		ips = append(ips, ipParam{tun.Name, tun.IpNets})
+	}
+	for _, v := range config.Veths {
+		ips = append(ips, ipParam{v.Name, v.IpNets})
+		if peerInHost(v) {
+			ips = append(ips, ipParam{v.Peer, v.PeerIpNets})
+		}
+	}
+	for _, m := range config.Macvlans {
+		ips = append(ips, ipParam{m.Name, m.IpNets})
+	}
+	for _, i := range config.Ipvlans {
+		ips = append(ips, ipParam{i.Name, i.IpNets})
+	}
+	return ips
+}
+
+func findIP(name string, config Config) (ipParam, bool) {
+	for _, i := range getIPs(config) {
+		if i.Name == name {
+			return i, true
+		}
+	}
+	return ipParam{}, false
+}
+
+// SetLinkState brings an interface up or down in system and keeps the state in database,
+// so that the next apply or reconcile does not undo it
+func SetLinkState(name string, state string) error {
+	if name == getAdminInterface() {
+		return ErrAdminLink
+	}
+	applyLock.Lock()
+	defer applyLock.Unlock()
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	found := false
+	for i := range userConfig.Devices {
+		if userConfig.Devices[i].Name == name {
+			userConfig.Devices[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Bonds {
+		if userConfig.Bonds[i].Name == name {
+			userConfig.Bonds[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Vlans {
+		if userConfig.Vlans[i].Name == name {
+			userConfig.Vlans[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Bridges {
+		if userConfig.Bridges[i].Name == name {
+			userConfig.Bridges[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Vxlans {
+		if userConfig.Vxlans[i].Name == name {
+			userConfig.Vxlans[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Tunnels {
+		if userConfig.Tunnels[i].Name == name {
+			userConfig.Tunnels[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Veths {
+		if userConfig.Veths[i].Name == name {
+			userConfig.Veths[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Macvlans {
+		if userConfig.Macvlans[i].Name == name {
+			userConfig.Macvlans[i].State, found = state, true
+		}
+	}
+	for i := range userConfig.Ipvlans {
+		if userConfig.Ipvlans[i].Name == name {
+			userConfig.Ipvlans[i].State, found = state, true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+
+	if err := setLinkState(name, state); err != nil {
+		return err
+	}
 	if err := PutToDataSource(userConfig); err != nil {
 		log.WithError(err).Error("Put data to database fail")
 		return err
@@ -554,19 +1481,269 @@ func DelIP(name string, ipNet string) error {
 	return nil
 }
 
+// the fields set by the bond, bridge and vlan API, the IPs are set by the IP API
+func bondSettings(b Bond) Bond {
+	return Bond{Name: b.Name, Mode: b.Mode, Devs: b.Devs, Mtu: b.Mtu, HardwareAddr: b.HardwareAddr, Alias: b.Alias, State: b.State}
+}
+
+func bridgeSettings(br Bridge) Bridge {
+	return Bridge{Name: br.Name, Devs: br.Devs, Mtu: br.Mtu, Stp: br.Stp, HardwareAddr: br.HardwareAddr, Alias: br.Alias, State: br.State}
+}
+
+func vlanSettings(v Vlan) Vlan {
+	return Vlan{Name: v.Name, Tag: v.Tag, Parent: v.Parent, Mtu: v.Mtu, HardwareAddr: v.HardwareAddr, Alias: v.Alias, State: v.State}
+}
+
+// empty keeps the kernel's default, off
+func validStp(stp string) bool {
+	return stp == "" || stp == "on" || stp == "off"
+}
+
 func validate(name string, dev []string, userConfig Config) error {
 	if isLinkAlreadyExists(name, userConfig) {
 		log.WithError(ErrNameUsed).Error("Name:" + name)
 		return ErrNameUsed
 	}
 
-	if isDevsAlreadyUsed(dev, userConfig) {
+	if isDevsAlreadyUsed(resolveRefs(userConfig.Devices, dev), resolveDevs(userConfig)) {
 		log.WithError(ErrDevsUsed)
 		return ErrDevsUsed
 	}
 	return nil
 }
 
+// validate the whole config: names are unique and not empty, every dev has only one master
+// and exists, vlan's parent exists, bond mode, vlan tag and IPs are legal
+func validateConfig(config Config) error {
+	matched := make(map[string]bool)
+	for _, d := range config.Devices {
+		if err := validateMatch(d); err != nil {
+			return err
+		}
+		for _, m := range []string{MAC_REF + strings.ToLower(d.MatchMac), PCI_REF + strings.ToLower(d.MatchPci)} {
+			if m == MAC_REF || m == PCI_REF {
+				continue
+			}
+			if matched[m] {
+				log.WithError(ErrMatchUsed).Error("Match:" + m)
+				return ErrMatchUsed
+			}
+			matched[m] = true
+		}
+	}
+	// the unmatched mac: and pci: references are reported as missing devs below
+	config = resolveDevs(config)
+
+	names := make(map[string]bool)
+	for _, name := range linkNames(config) {
+		if name == "" {
+			return ErrNameNull
+		}
+		if names[name] {
+			log.WithError(ErrNameUsed).Error("Name:" + name)
+			return ErrNameUsed
+		}
+		names[name] = true
+	}
+
+	slaves := make(map[string]bool)
+	var devs []string
+	for _, b := range config.Bonds {
+		if b.Mode < 0 || b.Mode > 6 {
+			return ErrBondMode
+		}
+		devs = append(devs, b.Devs...)
+	}
+	for _, br := range config.Bridges {
+		if !validStp(br.Stp) {
+			return ErrStp
+		}
+		devs = append(devs, br.Devs...)
+	}
+	for _, dev := range devs {
+		if !names[dev] {
+			log.WithError(ErrDevsNull).Error("Dev:" + dev)
+			return ErrDevsNull
+		}
+		if slaves[dev] {
+			log.WithError(ErrDevsUsed).Error("Dev:" + dev)
+			return ErrDevsUsed
+		}
+		slaves[dev] = true
+	}
+
+	for _, v := range config.Vlans {
+		if !names[v.Parent] {
+			log.WithError(ErrDevsNull).Error("Parent:" + v.Parent)
+			return ErrDevsNull
+		}
+		if v.Tag < 1 || v.Tag > 4094 {
+			return ErrVlanTag
+		}
+	}
+	for _, x := range config.Vxlans {
+		if err := validateVxlan(x, names); err != nil {
+			return err
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if err := validateTunnel(tun, config); err != nil {
+			return err
+		}
+	}
+	for _, v := range config.Veths {
+		if err := validateVeth(v); err != nil {
+			return err
+		}
+	}
+	for _, m := range config.Macvlans {
+		if !names[m.Parent] {
+			log.WithError(ErrDevsNull).Error("Parent:" + m.Parent)
+			return ErrDevsNull
+		}
+		if _, ok := macvlanMode(m.Mode); !ok {
+			return ErrMacvlanMode
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if !names[i.Parent] {
+			log.WithError(ErrDevsNull).Error("Parent:" + i.Parent)
+			return ErrDevsNull
+		}
+		if _, ok := ipvlanMode(i.Mode); !ok {
+			return ErrIpvlanMode
+		}
+	}
+
+	for _, i := range getIPs(config) {
+		if err := validateIpNets(i.Name, i.Ip); err != nil {
+			return err
+		}
+	}
+	return validateLinkSettings(config)
+}
+
+func validateIpNets(name string, ipNets []string) error {
+	for _, ipNet := range ipNets {
+		if _, err := netlink.ParseAddr(ipNet); err != nil {
+			log.WithError(err).Error("Parse IP " + ipNet + " of " + name + " failed")
+			return err
+		}
+	}
+	return nil
+}
+
+// mtu, mac, alias and state are legal, the mtu of a vlan, macvlan or ipvlan is not larger than its parent's and the members
+// of a bond have the same mtu, when they are set
+func validateLinkSettings(config Config) error {
+	mtus := make(map[string]int)
+	check := func(name string, mtu int, mac string, alias string, state string) error {
+		if state != "" && state != UP && state != DOWN {
+			log.WithError(ErrState).Error("Name:" + name)
+			return ErrState
+		}
+		if mtu != 0 && (mtu < 68 || mtu > 65535) {
+			log.WithError(ErrMtu).Error("Name:" + name)
+			return ErrMtu
+		}
+		if _, err := net.ParseMAC(mac); mac != "" && err != nil {
+			log.WithError(ErrMac).Error("Name:" + name)
+			return ErrMac
+		}
+		if len(alias) > 255 {
+			log.WithError(ErrAlias).Error("Name:" + name)
+			return ErrAlias
+		}
+		mtus[name] = mtu
+		return nil
+	}
+	for _, d := range config.Devices {
+		if err := check(d.Name, d.Mtu, d.HardwareAddr, d.Alias, d.State); err != nil {
+			return err
+		}
+	}
+	for _, b := range config.Bonds {
+		if err := check(b.Name, b.Mtu, b.HardwareAddr, b.Alias, b.State); err != nil {
+			return err
+		}
+	}
+	for _, v := range config.Vlans {
+		if err := check(v.Name, v.Mtu, v.HardwareAddr, v.Alias, v.State); err != nil {
+			return err
+		}
+	}
+	for _, br := range config.Bridges {
+		if err := check(br.Name, br.Mtu, br.HardwareAddr, br.Alias, br.State); err != nil {
+			return err
+		}
+	}
+	for _, x := range config.Vxlans {
+		if err := check(x.Name, x.Mtu, x.HardwareAddr, x.Alias, x.State); err != nil {
+			return err
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if err := check(tun.Name, tun.Mtu, tun.HardwareAddr, tun.Alias, tun.State); err != nil {
+			return err
+		}
+	}
+	for _, v := range config.Veths {
+		if err := check(v.Name, v.Mtu, v.HardwareAddr, v.Alias, v.State); err != nil {
+			return err
+		}
+	}
+	for _, m := range config.Macvlans {
+		if err := check(m.Name, m.Mtu, m.HardwareAddr, m.Alias, m.State); err != nil {
+			return err
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if err := check(i.Name, i.Mtu, "", i.Alias, i.State); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range config.Vlans {
+		if parent := mtus[v.Parent]; v.Mtu != 0 && parent != 0 && v.Mtu > parent {
+			log.WithError(ErrVlanMtu).Error("Vlan:" + v.Name)
+			return ErrVlanMtu
+		}
+	}
+	for _, m := range config.Macvlans {
+		if parent := mtus[m.Parent]; m.Mtu != 0 && parent != 0 && m.Mtu > parent {
+			log.WithError(ErrVlanMtu).Error("Macvlan:" + m.Name)
+			return ErrVlanMtu
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if parent := mtus[i.Parent]; i.Mtu != 0 && parent != 0 && i.Mtu > parent {
+			log.WithError(ErrVlanMtu).Error("Ipvlan:" + i.Name)
+			return ErrVlanMtu
+		}
+	}
+	for _, b := range resolveDevs(config).Bonds {
+		mtu := b.Mtu
+		for _, dev := range b.Devs {
+			if m := mtus[dev]; m != 0 && mtu != 0 && m != mtu {
+				log.WithError(ErrBondMtu).Error("Bond:" + b.Name + " Dev:" + dev)
+				return ErrBondMtu
+			} else if mtu == 0 {
+				mtu = m
+			}
+		}
+	}
+	return nil
+}
+
+// names of all the interfaces in config
+func linkNames(config Config) []string {
+	var names []string
+	for _, i := range getIPs(config) {
+		names = append(names, i.Name)
+	}
+	return names
+}
+
 func isDevsAlreadyUsed(devs []string, config Config) bool {
 	for _, dev := range devs {
 		for _, b := range config.Bonds {
@@ -608,6 +1785,31 @@ func isLinkAlreadyExists(name string, config Config) bool {
 			return true
 		}
 	}
+	for _, x := range config.Vxlans {
+		if x.Name == name {
+			return true
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if tun.Name == name {
+			return true
+		}
+	}
+	for _, v := range config.Veths {
+		if v.Name == name || peerInHost(v) && v.Peer == name {
+			return true
+		}
+	}
+	for _, m := range config.Macvlans {
+		if m.Name == name {
+			return true
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if i.Name == name {
+			return true
+		}
+	}
 	return false
 }
 
@@ -659,6 +1861,75 @@ func getVlanJSONParam(req *http.Request) (Vlan, error) {
 	return v, nil
 }
 
+func getConfigJSONParam(req *http.Request) (Config, error) {
+	req.ParseForm()
+	var config Config
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &config); err != nil {
+		return Config{}, errors.New("用户输入参数格式有误")
+	}
+	return config, nil
+}
+
+// used to unmarshal req, Interval and MaxBackoff are durations like 30s, 10m, empty means unchanged
+type reconcileParam struct {
+	Enabled    *bool
+	Interval   string
+	MaxBackoff string
+
+	interval   time.Duration
+	maxBackoff time.Duration
+}
+
+func getAuditFilterParam(req *http.Request) (AuditFilter, error) {
+	query := req.URL.Query()
+	filter := AuditFilter{Resource: query.Get("resource"), Principal: query.Get("principal"), Limit: 100}
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return AuditFilter{}, err
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return AuditFilter{}, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return AuditFilter{}, errors.New("limit should be a non-negative number")
+		}
+	}
+	return filter, nil
+}
+
+func getReconcileJSONParam(req *http.Request) (reconcileParam, error) {
+	req.ParseForm()
+	var r reconcileParam
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &r); err != nil {
+		return reconcileParam{}, errors.New("用户输入参数格式有误")
+	}
+	// 0 when not given
+	parse := func(d string) (time.Duration, error) {
+		if d == "" {
+			return 0, nil
+		}
+		if duration, err := time.ParseDuration(d); err == nil && duration > 0 {
+			return duration, nil
+		}
+		return 0, errors.New("Interval and MaxBackoff should be positive durations like 30s")
+	}
+	var err error
+	if r.interval, err = parse(r.Interval); err != nil {
+		return reconcileParam{}, err
+	}
+	if r.maxBackoff, err = parse(r.MaxBackoff); err != nil {
+		return reconcileParam{}, err
+	}
+	return r, nil
+}
+
 // used to unmarshal req
 type ipParam struct {
 	Name string
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,15 +36,15 @@ func TestGetConfigFromDs(t *testing.T) {
 }
 
 func TestBondAdd(t *testing.T) {
-	err := BondAdd("bond1", 0, []string{"eth4"})
+	err := BondAdd(Bond{Name: "bond1", Mode: 0, Devs: []string{"eth4"}})
 	config, _ := GetConfigFromDs()
 	assert.Equal(t, Bond{Name: "bond1", Devs: []string{"eth4"}}, config.Bonds[1])
 	assert.Nil(t, err)
 
-	err2 := BondAdd("bond0", 0, []string{})
+	err2 := BondAdd(Bond{Name: "bond0", Mode: 0, Devs: []string{}})
 	assert.Error(t, err2, "Name alerady exists")
 
-	err3 := BondAdd("bond2", 0, []string{"eth2"})
+	err3 := BondAdd(Bond{Name: "bond2", Mode: 0, Devs: []string{"eth2"}})
 	assert.Error(t, err3, "dev has alerady been occupied")
 }
 
@@ -56,16 +57,22 @@ func TestBondDel(t *testing.T) {
 }
 
 func TestBridgeAdd(t *testing.T) {
-	err := BridgeAdd("bridge1", []string{"eth5"}, 1333)
+	err := BridgeAdd(Bridge{Name: "bridge1", Devs: []string{"eth5"}, Mtu: 1333})
 	config, _ := GetConfigFromDs()
 	assert.Equal(t, Bridge{Name: "bridge1", Devs: []string{"eth5"}, Mtu: 1333}, config.Bridges[1])
 	assert.Nil(t, err)
 
-	err2 := BridgeAdd("bridge0", []string{}, 1333)
+	err2 := BridgeAdd(Bridge{Name: "bridge0", Devs: []string{}, Mtu: 1333})
 	assert.Error(t, err2, "Name alerady exists")
 
-	err3 := BridgeAdd("bridge2", []string{"eth4"}, 1333)
+	err3 := BridgeAdd(Bridge{Name: "bridge2", Devs: []string{"eth4"}, Mtu: 1333})
 	assert.Error(t, err3, "dev has alerady been occupied")
+
+	assert.Equal(t, ErrStp, BridgeAdd(Bridge{Name: "bridge2", Devs: []string{}, Stp: "yes"}))
+	assert.Nil(t, BridgeAdd(Bridge{Name: "bridge2", Devs: []string{}, Stp: "on"}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, Bridge{Name: "bridge2", Devs: []string{}, Stp: "on"}, config.Bridges[2])
+	BridgeDel("bridge2")
 }
 
 func TestBridgeDel(t *testing.T) {
@@ -77,12 +84,12 @@ func TestBridgeDel(t *testing.T) {
 }
 
 func TestVlanAdd(t *testing.T) {
-	err := VlanAdd("vlan1", 200, "eth1")
+	err := VlanAdd(Vlan{Name: "vlan1", Tag: 200, Parent: "eth1"})
 	config, _ := GetConfigFromDs()
 	assert.Equal(t, Vlan{Name: "vlan1", Tag: 200, Parent: "eth1"}, config.Vlans[1])
 	assert.Nil(t, err)
 
-	err2 := VlanAdd("vlan0", 0, "")
+	err2 := VlanAdd(Vlan{Name: "vlan0", Tag: 0, Parent: ""})
 	assert.Error(t, err2, "Name alerady exists")
 }
 
@@ -94,8 +101,26 @@ func TestVlanDel(t *testing.T) {
 	assert.Equal(t, 1, len(config.Bonds))
 }
 
+func TestBondUpdate(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	assert.Nil(t, BondAdd(Bond{Name: "bond8", Devs: []string{}}))
+	assert.Nil(t, AssignIP("bond8", []string{"8.8.8.8/24"}))
+
+	assert.Equal(t, ErrMtu, BondUpdate(Bond{Name: "bond8", Devs: []string{}, Mtu: 1}))
+	config, _ := GetConfigFromDs()
+	bond, ok := findBond("bond8", config)
+	assert.True(t, ok)
+	assert.Equal(t, Bond{Name: "bond8", Devs: []string{}, IpNets: []string{"8.8.8.8/24"}}, bond)
+
+	assert.Nil(t, BondUpdate(Bond{Name: "bond8", Mode: 1, Devs: []string{}, Mtu: 1400}))
+	config, _ = GetConfigFromDs()
+	bond, _ = findBond("bond8", config)
+	assert.Equal(t, Bond{Name: "bond8", Mode: 1, Devs: []string{}, IpNets: []string{"8.8.8.8/24"}, Mtu: 1400}, bond)
+}
+
 func TestAssignIP(t *testing.T) {
-	BondAdd("bond9", 0, []string{})
+	BondAdd(Bond{Name: "bond9", Mode: 0, Devs: []string{}})
 	AssignIP("eth0", []string{"1.1.1.1/24", "2.2.2.2/24", "3.3.3.3/24"})
 	AssignIP("bond9", []string{"33.33.33.33/24"})
 	config, _ := GetConfigFromDs()
@@ -113,6 +138,28 @@ func TestAssignIP(t *testing.T) {
 	BondDel("bond9")
 }
 
+func TestAssignIPToEveryKind(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(Config{
+		Devices:  []Device{{Name: "eth0"}},
+		Macvlans: []Macvlan{{Name: "mv0", Parent: "eth0"}},
+		Veths:    []Veth{{Name: "veth0", Peer: "veth1"}},
+	})
+
+	assert.Nil(t, AssignIP("mv0", []string{"10.0.0.5/24"}))
+	assert.Nil(t, AssignIP("veth1", []string{"10.0.1.2/24"}))
+	assert.Equal(t, ErrNotFound, AssignIP("eth9", []string{"10.0.2.2/24"}))
+	config, _ := GetConfigFromDs()
+	assert.Equal(t, []string{"10.0.0.5/24"}, config.Macvlans[0].IpNets)
+	assert.Equal(t, []string{"10.0.1.2/24"}, config.Veths[0].PeerIpNets)
+
+	assert.Nil(t, DelIP("mv0", "10.0.0.5/24"))
+	assert.Equal(t, ErrNotFound, DelIP("eth9", "10.0.2.2/24"))
+	config, _ = GetConfigFromDs()
+	assert.Empty(t, config.Macvlans[0].IpNets)
+}
+
 func TestDelIP(t *testing.T) {
 	DelIP("eth0", "2.2.2.2/24")
 	config, _ := GetConfigFromDs()
@@ -122,3 +169,197 @@ func TestDelIP(t *testing.T) {
 		}
 	}
 }
+
+func TestFindLink(t *testing.T) {
+	device, ok := findDevice("eth5", gconfig)
+	assert.True(t, ok)
+	assert.Equal(t, Device{Name: "eth5"}, device)
+
+	bond, ok := findBond("bond0", gconfig)
+	assert.True(t, ok)
+	assert.Equal(t, Bond{Name: "bond0", Devs: []string{"eth0", "eth1"}}, bond)
+
+	bri, ok := findBridge("bridge0", gconfig)
+	assert.True(t, ok)
+	assert.Equal(t, Bridge{Name: "bridge0", Devs: []string{"eth2", "eth3"}, Mtu: 1300}, bri)
+
+	v, ok := findVlan("vlan0", gconfig)
+	assert.True(t, ok)
+	assert.Equal(t, Vlan{Name: "vlan0", Tag: 100, Parent: "eth0"}, v)
+
+	_, ok = findBond("bond99", gconfig)
+	assert.False(t, ok)
+}
+
+func TestGetIPs(t *testing.T) {
+	config, _ := GetConfigFromDs()
+	i, ok := findIP("eth0", config)
+	assert.True(t, ok)
+	assert.Equal(t, ipParam{Name: "eth0", Ip: []string{"1.1.1.1/24", "3.3.3.3/24"}}, i)
+	assert.Equal(t, len(config.Devices)+len(config.Bonds)+len(config.Vlans)+len(config.Bridges), len(getIPs(config)))
+
+	_, ok = findIP("eth99", config)
+	assert.False(t, ok)
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.Nil(t, validateConfig(gconfig))
+
+	config := gconfig
+	config.Bonds = []Bond{{Name: "eth0"}}
+	assert.Equal(t, ErrNameUsed, validateConfig(config))
+
+	config = gconfig
+	config.Bonds = []Bond{{Name: "bond1", Devs: []string{"eth2"}}}
+	assert.Equal(t, ErrDevsUsed, validateConfig(config))
+
+	config = gconfig
+	config.Bonds = []Bond{{Name: "bond1", Devs: []string{"eth9"}}}
+	assert.Equal(t, ErrDevsNull, validateConfig(config))
+
+	config = gconfig
+	config.Bonds = []Bond{{Name: "bond1", Mode: 7}}
+	assert.Equal(t, ErrBondMode, validateConfig(config))
+
+	config = gconfig
+	config.Vlans = []Vlan{{Name: "vlan1", Tag: 5000, Parent: "eth0"}}
+	assert.Equal(t, ErrVlanTag, validateConfig(config))
+
+	config = gconfig
+	config.Vlans = []Vlan{{Name: "", Tag: 100, Parent: "eth0"}}
+	assert.Equal(t, ErrNameNull, validateConfig(config))
+
+	config = gconfig
+	config.Vlans = []Vlan{{Name: "vlan1", Tag: 100, Parent: "eth0", IpNets: []string{"1.1.1.1"}}}
+	assert.Error(t, validateConfig(config))
+}
+
+func TestValidateLinkSettings(t *testing.T) {
+	config := Config{
+		Devices: []Device{{Name: "eth0", Mtu: 9000}, {Name: "eth1"}},
+		Bonds:   []Bond{{Name: "bond0", Devs: []string{"eth0", "eth1"}, Mtu: 9000, HardwareAddr: "52:54:00:12:34:56", Alias: "storage"}},
+		Vlans:   []Vlan{{Name: "vlan100", Tag: 100, Parent: "bond0", Mtu: 9000}},
+	}
+	assert.Nil(t, validateConfig(config))
+
+	config.Vlans[0].Mtu = 9001
+	assert.Equal(t, ErrVlanMtu, validateConfig(config))
+	config.Vlans[0].Mtu = 0
+
+	config.Devices[1].Mtu = 1500
+	assert.Equal(t, ErrBondMtu, validateConfig(config))
+	config.Bonds[0].Mtu = 0
+	assert.Equal(t, ErrBondMtu, validateConfig(config))
+	config.Devices[1].Mtu = 0
+
+	config.Devices[0].Mtu = 65536
+	assert.Equal(t, ErrMtu, validateConfig(config))
+	config.Devices[0].Mtu = 9000
+
+	config.Bonds[0].HardwareAddr = "52:54:00"
+	assert.Equal(t, ErrMac, validateConfig(config))
+	config.Bonds[0].HardwareAddr = ""
+
+	config.Bonds[0].Alias = strings.Repeat("a", 256)
+	assert.Equal(t, ErrAlias, validateConfig(config))
+	config.Bonds[0].Alias = ""
+
+	config.Vlans[0].State = "off"
+	assert.Equal(t, ErrState, validateConfig(config))
+}
+
+func TestValidateMacvlan(t *testing.T) {
+	config := Config{
+		Devices:  []Device{{Name: "eth0", Mtu: 9000}},
+		Vlans:    []Vlan{{Name: "vlan100", Tag: 100, Parent: "eth0"}},
+		Macvlans: []Macvlan{{Name: "mv0", Parent: "eth0", Mode: "private", IpNets: []string{"10.0.0.5/24"}}},
+		Ipvlans:  []Ipvlan{{Name: "ipv0", Parent: "vlan100"}},
+	}
+	assert.Nil(t, validateConfig(config))
+
+	config.Macvlans[0].Parent = "eth9"
+	assert.Equal(t, ErrDevsNull, validateConfig(config))
+	config.Macvlans[0].Parent = "eth0"
+
+	config.Macvlans[0].Mode = "l2"
+	assert.Equal(t, ErrMacvlanMode, validateConfig(config))
+	config.Macvlans[0].Mode = ""
+
+	config.Ipvlans[0].Mode = "bridge"
+	assert.Equal(t, ErrIpvlanMode, validateConfig(config))
+	config.Ipvlans[0].Mode = "l3s"
+
+	config.Macvlans[0].Mtu = 9001
+	assert.Equal(t, ErrVlanMtu, validateConfig(config))
+	config.Macvlans[0].Mtu = 0
+
+	config.Ipvlans[0].Name = "mv0"
+	assert.Equal(t, ErrNameUsed, validateConfig(config))
+}
+
+func TestMacvlanUpdate(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(gconfig)
+	assert.Nil(t, MacvlanAdd(Macvlan{Name: "mv0", Parent: "eth5", IpNets: []string{"10.0.0.5/24"}}))
+	assert.Error(t, MacvlanAdd(Macvlan{Name: "mv1", Parent: "eth5", IpNets: []string{"10.0.0.300/24"}}))
+
+	assert.Equal(t, ErrMacvlanMode, MacvlanUpdate(Macvlan{Name: "mv0", Parent: "eth5", Mode: "l2"}))
+	config, _ := GetConfigFromDs()
+	assert.Equal(t, []Macvlan{{Name: "mv0", Parent: "eth5", IpNets: []string{"10.0.0.5/24"}}}, config.Macvlans)
+
+	// the IPs are kept, they are changed by the IP API
+	assert.Nil(t, MacvlanUpdate(Macvlan{Name: "mv0", Parent: "eth5", Mode: "vepa", IpNets: []string{"10.0.0.6/24"}}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Macvlan{{Name: "mv0", Parent: "eth5", Mode: "vepa", IpNets: []string{"10.0.0.5/24"}}}, config.Macvlans)
+
+	assert.Nil(t, IpvlanAdd(Ipvlan{Name: "ipvl0", Parent: "eth5", IpNets: []string{"10.0.1.5/24"}}))
+	assert.Nil(t, IpvlanUpdate(Ipvlan{Name: "ipvl0", Parent: "eth5", Mode: "l3", Mtu: 1400}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Ipvlan{{Name: "ipvl0", Parent: "eth5", Mode: "l3", Mtu: 1400, IpNets: []string{"10.0.1.5/24"}}}, config.Ipvlans)
+}
+
+func TestConfigReplace(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+
+	config := gconfig
+	config.Bonds = []Bond{{Name: "bond1", Devs: []string{"eth2"}}}
+	assert.Error(t, ConfigReplace(config))
+	unchanged, _ := GetConfigFromDs()
+	assert.Equal(t, old, unchanged)
+
+	assert.Nil(t, ConfigReplace(gconfig))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, gconfig, config)
+}
+
+func TestConfigPatch(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(gconfig)
+
+	// move eth3 from bridge0 to bond0 in one request
+	config, err := ConfigPatch("application/json-patch+json", []byte(`[
+		{"op": "remove", "path": "/Bridges/0/Devs/1"},
+		{"op": "add", "path": "/Bonds/0/Devs/-", "value": "eth3"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"eth0", "eth1", "eth3"}, config.Bonds[0].Devs)
+	assert.Equal(t, []string{"eth2"}, config.Bridges[0].Devs)
+
+	config, err = ConfigPatch("application/merge-patch+json", []byte(`{"HostId": "2", "Vlans": null}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", config.HostId)
+	assert.Nil(t, config.Vlans)
+	assert.Equal(t, []string{"eth0", "eth1", "eth3"}, config.Bonds[0].Devs)
+
+	_, err = ConfigPatch("application/merge-patch+json", []byte(`{"Vlans": [{"Name": "eth0", "Parent": "eth1", "Tag": 1}]}`))
+	assert.Equal(t, ErrNameUsed, err)
+
+	_, err = ConfigPatch("application/json", []byte(`{}`))
+	assert.Equal(t, ErrPatch, err)
+
+	saved, _ := GetConfigFromDs()
+	assert.Equal(t, "2", saved.HostId)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// disabled until the daemon config is applied
+var auditLog = &AuditLog{}
+
+// one line in the audit log
+type AuditRecord struct {
+	Time       time.Time
+	Principal  string          `json:",omitempty"`
+	RemoteAddr string          `json:",omitempty"`
+	Method     string          `json:",omitempty"`
+	Path       string          `json:",omitempty"`
+	Resource   string          // bond, bridge, vlan, ip, config, apply, init or reconcile
+	Body       json.RawMessage `json:",omitempty"`
+	Changes    []Drift         `json:",omitempty"` // what changed on the affected objects, Desired is before and Live is after
+	Status     bool
+	Code       int
+	Message    string `json:",omitempty"`
+}
+
+// AuditLog appends JSON lines to file, which is rotated by size, the backups are kept in the same directory.
+// No file means audit is disabled
+type AuditLog struct {
+	mu     sync.Mutex
+	file   string
+	logger *lumberjack.Logger
+}
+
+func NewAuditLog(file string, maxSize int, maxBackups int, maxAge int) *AuditLog {
+	a := &AuditLog{}
+	a.Set(file, maxSize, maxBackups, maxAge)
+	return a
+}
+
+// Set closes the current file and switches to the new settings, maxSize is in megabytes,
+// maxBackups and maxAge (days) of 0 keep all backups
+func (a *AuditLog) Set(file string, maxSize int, maxBackups int, maxAge int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.logger != nil {
+		a.logger.Close()
+		a.logger = nil
+	}
+	a.file = file
+	if file != "" {
+		a.logger = &lumberjack.Logger{
+			Filename:   file,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAge,
+			LocalTime:  true,
+		}
+	}
+}
+
+func (a *AuditLog) Enabled() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.logger != nil
+}
+
+func (a *AuditLog) Record(r AuditRecord) {
+	if !a.Enabled() {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.WithError(err).Error("Audit record convert json failed")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.logger == nil {
+		return
+	}
+	if _, err := a.logger.Write(append(data, '\n')); err != nil {
+		log.WithError(err).Error("Write audit log " + a.file + " failed")
+	}
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.logger == nil {
+		return nil
+	}
+	return a.logger.Close()
+}
+
+type AuditFilter struct {
+	Since     time.Time
+	Until     time.Time
+	Resource  string
+	Principal string
+	Limit     int // the latest ones are kept, 0 means no limit
+}
+
+func (f AuditFilter) match(r AuditRecord) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	if f.Resource != "" && !strings.EqualFold(f.Resource, r.Resource) {
+		return false
+	}
+	if f.Principal != "" && f.Principal != r.Principal {
+		return false
+	}
+	return true
+}
+
+// Query reads the backups and the current file, oldest first
+func (a *AuditLog) Query(f AuditFilter) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	if a == nil {
+		return records, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == "" {
+		return records, nil
+	}
+
+	// backups are named like audit-2006-01-02T15-04-05.000.log, so they sort by time
+	ext := filepath.Ext(a.file)
+	backups, err := filepath.Glob(strings.TrimSuffix(a.file, ext) + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+
+	for _, file := range append(backups, a.file) {
+		in, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("Open audit log " + file + " failed")
+			return nil, err
+		}
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var r AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				continue
+			}
+			if f.match(r) {
+				records = append(records, r)
+			}
+		}
+		err = scanner.Err()
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[len(records)-f.Limit:]
+	}
+	return records, nil
+}
+
+// record an apply not made through the API, before is the system config before it
+func auditApply(principal string, before Config, err error) {
+	r := AuditRecord{Principal: principal, Resource: "apply", Status: err == nil, Code: http.StatusOK, Message: "应用网络配置成功"}
+	if err != nil {
+		r.Code, r.Message = http.StatusInternalServerError, "应用网络配置失败."+err.Error()
+	}
+	if after, afterErr := GetConfigFromSys(); afterErr == nil {
+		r.Changes = Diff(before, after)
+	}
+	auditLog.Record(r)
+}
+
+// AuditHandler records every request that changes the database or the system,
+// it should be wrapped by AuthHandler so that the principal is known
+type AuditHandler struct {
+	next http.Handler
+}
+
+func NewAuditHandler(next http.Handler) *AuditHandler {
+	return &AuditHandler{next: next}
+}
+
+// apply and init change the system with GET
+func changesSystem(req *http.Request) bool {
+	return req.URL.Path == "/network/apply" || req.URL.Path == "/network/init"
+}
+
+func (h *AuditHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !auditLog.Enabled() || (req.Method == "GET" && !changesSystem(req)) {
+		h.next.ServeHTTP(resp, req)
+		return
+	}
+
+	// read the body and put it back for the handler
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	getConfig := GetConfigFromDs
+	if changesSystem(req) {
+		getConfig = GetConfigFromSys
+	}
+	before, beforeErr := getConfig()
+	rec := &auditResponse{ResponseWriter: resp, code: http.StatusOK}
+	h.next.ServeHTTP(rec, req)
+	after, afterErr := getConfig()
+
+	r := AuditRecord{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Resource:   auditResource(req.URL.Path),
+		RemoteAddr: req.RemoteAddr,
+		Body:       auditBody(body),
+		Code:       rec.code,
+		Status:     rec.code < http.StatusBadRequest,
+	}
+	if p := principalOf(req); p != nil {
+		r.Principal = p.Name
+	}
+	if beforeErr == nil && afterErr == nil {
+		r.Changes = Diff(before, after)
+	}
+	var rm ResponseMessage
+	if err := json.Unmarshal(rec.body.Bytes(), &rm); err == nil && rm.Code != 0 {
+		r.Status, r.Code, r.Message = rm.Status, rm.Code, rm.Message
+	}
+	auditLog.Record(r)
+}
+
+// eg: /network/bond/bond0 is bond
+func auditResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		return path
+	}
+	return strings.ToLower(parts[1])
+}
+
+// the body is kept as is if it is JSON, otherwise as a string
+func auditBody(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	data, _ := json.Marshal(string(body))
+	return json.RawMessage(data)
+}
+
+// keeps the status code and a copy of the body written to the client
+type auditResponse struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *auditResponse) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *auditResponse) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	oldLog, oldDs := auditLog, DataSource
+	defer func() { auditLog, DataSource = oldLog, oldDs }()
+	auditLog = NewAuditLog(filepath.Join(dir, "audit.log"), 1, 0, 0)
+	defer auditLog.Close()
+	DataSource = make(map[string]string)
+	PutToDataSource(Config{})
+
+	next := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			return
+		}
+		// the body is put back for the handler
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, `{"Name":"bond0","Mode":1}`, string(body))
+		PutToDataSource(Config{Bonds: []Bond{{Name: "bond0", Mode: 1}}})
+		resp.Write([]byte(`{"status":true,"message":"Bond添加成功","code":200}`))
+	})
+	handler := NewAuditHandler(next)
+
+	req := httptest.NewRequest("POST", "/network/bond/", strings.NewReader(`{"Name":"bond0","Mode":1}`))
+	req.RemoteAddr = "192.168.26.1:50000"
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), principalKey{}, &Principal{Name: "alice"})))
+	// reads are not audited
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/network/bond", nil))
+
+	records, err := auditLog.Query(AuditFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+	r := records[0]
+	assert.Equal(t, "alice", r.Principal)
+	assert.Equal(t, "192.168.26.1:50000", r.RemoteAddr)
+	assert.Equal(t, "bond", r.Resource)
+	assert.Equal(t, `{"Name":"bond0","Mode":1}`, string(r.Body))
+	assert.Equal(t, []Drift{{Kind: LINK, Action: ADDED, Type: BOND, Name: "bond0"}}, r.Changes)
+	assert.True(t, r.Status)
+	assert.Equal(t, "Bond添加成功", r.Message)
+}
+
+func TestAuditQuery(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	a := NewAuditLog(file, 1, 0, 0)
+	defer a.Close()
+
+	now := time.Now()
+	a.Record(AuditRecord{Time: now.Add(-2 * time.Hour), Principal: "alice", Resource: "bond", Status: true})
+	a.Record(AuditRecord{Time: now.Add(-time.Hour), Principal: "bob", Resource: "vlan", Status: true})
+	a.Record(AuditRecord{Time: now, Principal: "alice", Resource: "apply"})
+	// a rotated backup is older than the current file
+	ioutil.WriteFile(filepath.Join(dir, "audit-2000-01-01T00-00-00.000.log"), []byte(`{"Time":"2000-01-01T00:00:00Z","Resource":"config"}`+"\n"+"broken\n"), 0600)
+
+	records, err := a.Query(AuditFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, "config", records[0].Resource)
+
+	records, _ = a.Query(AuditFilter{Resource: "BOND"})
+	assert.Equal(t, 1, len(records))
+	records, _ = a.Query(AuditFilter{Principal: "alice", Since: now.Add(-90 * time.Minute)})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "apply", records[0].Resource)
+	records, _ = a.Query(AuditFilter{Until: now.Add(-90 * time.Minute)})
+	assert.Equal(t, 2, len(records))
+	records, _ = a.Query(AuditFilter{Limit: 2})
+	assert.Equal(t, []string{"vlan", "apply"}, []string{records[0].Resource, records[1].Resource})
+
+	var disabled *AuditLog
+	disabled.Record(AuditRecord{})
+	records, err = disabled.Query(AuditFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
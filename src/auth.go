@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized, wrong or missing credential")
+	ErrForbidden    = errors.New("Forbidden")
+)
+
+// the paths anyone can access, so that supervisors need no credential
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+type principalKey struct{}
+
+// the authenticated caller
+type Principal struct {
+	Name   string
+	Method string   // token, basic or cert
+	Role   string   `json:",omitempty"`
+	Scopes []string `json:",omitempty"` // interface name patterns the principal may manage, empty means all
+}
+
+type Authenticator interface {
+	// returns nil principal and nil error if the request carries no credential of this kind
+	Authenticate(req *http.Request) (*Principal, error)
+}
+
+// static bearer tokens, the file has one "token principal" per line
+type TokenAuth struct {
+	tokens map[[sha256.Size]byte]string
+}
+
+func NewTokenAuth(file string) (*TokenAuth, error) {
+	lines, err := readAuthFile(file)
+	if err != nil {
+		return nil, err
+	}
+	a := &TokenAuth{tokens: make(map[[sha256.Size]byte]string)}
+	for _, fields := range lines {
+		if len(fields) < 2 {
+			return nil, errors.New("Token file " + file + " should have lines like: token principal")
+		}
+		a.tokens[sha256.Sum256([]byte(fields[0]))] = fields[1]
+	}
+	return a, nil
+}
+
+func (a *TokenAuth) Authenticate(req *http.Request) (*Principal, error) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, nil
+	}
+	// compare the hashes so that the lookup time does not depend on the token
+	name, ok := a.tokens[sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{Name: name, Method: "token"}, nil
+}
+
+// http basic auth, the file has one "user:bcrypt-hash" per line, eg generated by htpasswd -B
+type BasicAuth struct {
+	hashes map[string][]byte
+}
+
+func NewBasicAuth(file string) (*BasicAuth, error) {
+	lines, err := readAuthFile(file)
+	if err != nil {
+		return nil, err
+	}
+	a := &BasicAuth{hashes: make(map[string][]byte)}
+	for _, fields := range lines {
+		userHash := strings.SplitN(fields[0], ":", 2)
+		if len(userHash) != 2 {
+			return nil, errors.New("Password file " + file + " should have lines like: user:bcrypt-hash")
+		}
+		a.hashes[userHash[0]] = []byte(userHash[1])
+	}
+	return a, nil
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) (*Principal, error) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	hash, ok := a.hashes[user]
+	if !ok || bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{Name: user, Method: "basic"}, nil
+}
+
+// client certificate verified by the TLS listener, the principal is the subject's common name,
+// only the subjects listed are allowed if any
+type CertAuth struct {
+	subjects map[string]bool
+}
+
+func NewCertAuth(subjects []string) *CertAuth {
+	a := &CertAuth{subjects: make(map[string]bool)}
+	for _, s := range subjects {
+		if s = strings.TrimSpace(s); s != "" {
+			a.subjects[s] = true
+		}
+	}
+	return a
+}
+
+func (a *CertAuth) Authenticate(req *http.Request) (*Principal, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	subject := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(a.subjects) > 0 && !a.subjects[subject] {
+		return nil, ErrForbidden
+	}
+	return &Principal{Name: subject, Method: "cert"}, nil
+}
+
+// token and basic auth are enabled when their files are given, cert auth needs the TLS listener
+func LoadAuthenticators(tokenFile string, passwordFile string, certAuth bool, certSubjects []string) ([]Authenticator, error) {
+	var auths []Authenticator
+	if tokenFile != "" {
+		a, err := NewTokenAuth(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, a)
+	}
+	if passwordFile != "" {
+		a, err := NewBasicAuth(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, a)
+	}
+	if certAuth {
+		auths = append(auths, NewCertAuth(certSubjects))
+	}
+	return auths, nil
+}
+
+// the authenticators and roles configured in c
+func LoadAccess(c DaemonConfig) ([]Authenticator, map[string]Principal, error) {
+	auths, err := LoadAuthenticators(c.TokenFile, c.PasswordFile, c.CertAuth, c.CertSubjects)
+	if err != nil {
+		return nil, nil, err
+	}
+	var roles map[string]Principal
+	if c.RoleFile != "" {
+		if roles, err = LoadRoles(c.RoleFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	return auths, roles, nil
+}
+
+// every line split by spaces, empty lines and comments starting with # are skipped
+func readAuthFile(file string) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.WithError(err).Error("Open auth file " + file + " failed")
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Fields(line))
+	}
+	return lines, scanner.Err()
+}
+
+// AuthHandler authenticates and authorizes every request before passing it to next,
+// no authenticator means authentication is disabled, no roles means every caller is operator
+type AuthHandler struct {
+	mu    sync.RWMutex
+	next  http.Handler
+	auths []Authenticator
+	roles map[string]Principal
+}
+
+func NewAuthHandler(next http.Handler, auths []Authenticator, roles map[string]Principal) *AuthHandler {
+	if len(auths) == 0 {
+		log.Warn("No authenticator configured, the API is open to everyone")
+	}
+	return &AuthHandler{next: next, auths: auths, roles: roles}
+}
+
+// Reload replaces the authenticators and roles, the requests being served are not affected
+func (h *AuthHandler) Reload(auths []Authenticator, roles map[string]Principal) {
+	if len(auths) == 0 {
+		log.Warn("No authenticator configured, the API is open to everyone")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.auths, h.roles = auths, roles
+}
+
+func (h *AuthHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	h.mu.RLock()
+	if len(h.auths) == 0 || publicPaths[req.URL.Path] {
+		h.mu.RUnlock()
+		h.next.ServeHTTP(resp, req)
+		return
+	}
+
+	p, err := h.authenticate(req)
+	if err == nil {
+		err = h.authorize(p, req)
+	}
+	h.mu.RUnlock()
+	entry := log.WithFields(log.Fields{"Method": req.Method, "Path": req.URL.Path, "RemoteAddr": req.RemoteAddr})
+	if p != nil {
+		entry = entry.WithField("Principal", p.Name)
+	}
+	if err != nil {
+		entry.WithError(err).Warn("认证失败")
+		code := http.StatusUnauthorized
+		if err == ErrForbidden {
+			code = http.StatusForbidden
+		} else {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="network_config", Basic realm="network_config"`)
+		}
+		writeError(resp, code, "认证失败."+err.Error())
+		return
+	}
+
+	entry.Info("API请求")
+	h.next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+}
+
+// the first authenticator recognizing the credential decides
+func (h *AuthHandler) authenticate(req *http.Request) (*Principal, error) {
+	for _, a := range h.auths {
+		p, err := a.Authenticate(req)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			return p, nil
+		}
+	}
+	return nil, ErrUnauthorized
+}
+
+// the authenticated caller of the request, nil when authentication is disabled
+func principalOf(req *http.Request) *Principal {
+	p, _ := req.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+func writeError(resp http.ResponseWriter, code int, message string) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	rm := ResponseMessage{Status: false, Message: message, Code: code}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
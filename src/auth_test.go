@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "auth")
+	assert.Nil(t, err)
+	f.WriteString(content)
+	f.Close()
+	return f.Name()
+}
+
+func TestTokenAuth(t *testing.T) {
+	file := writeTempFile(t, "# token principal\nsecret1 alice\n\nsecret2 bob\n")
+	defer os.Remove(file)
+	a, err := NewTokenAuth(file)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/network/config", nil)
+	p, err := a.Authenticate(req)
+	assert.Nil(t, p)
+	assert.Nil(t, err)
+
+	req.Header.Set("Authorization", "Bearer secret2")
+	p, err = a.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "bob", Method: "token"}, p)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrUnauthorized, err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("passw0rd"), bcrypt.MinCost)
+	file := writeTempFile(t, "admin:"+string(hash)+"\n")
+	defer os.Remove(file)
+	a, err := NewBasicAuth(file)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/network/config", nil)
+	req.SetBasicAuth("admin", "passw0rd")
+	p, err := a.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "admin", Method: "basic"}, p)
+
+	req.SetBasicAuth("admin", "wrong")
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrUnauthorized, err)
+}
+
+func TestCertAuth(t *testing.T) {
+	a := NewCertAuth([]string{"ops"})
+	req := httptest.NewRequest("GET", "/network/config", nil)
+	p, err := a.Authenticate(req)
+	assert.Nil(t, p)
+	assert.Nil(t, err)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	p, err = a.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "ops", Method: "cert"}, p)
+
+	cert.Subject.CommonName = "tenant"
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestAuthHandler(t *testing.T) {
+	file := writeTempFile(t, "secret1 alice\n")
+	defer os.Remove(file)
+	auths, err := LoadAuthenticators(file, "", false, nil)
+	assert.Nil(t, err)
+
+	var principal *Principal
+	h := NewAuthHandler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		principal = principalOf(req)
+	}), auths, nil)
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/network/init", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Nil(t, principal)
+
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	req := httptest.NewRequest("GET", "/network/init", nil)
+	req.Header.Set("Authorization", "Bearer secret1")
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "alice", principal.Name)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// the data source key of the config last applied successfully
+const lastGoodKey = "network.last-good"
+
+// how often to check for devices while waiting
+var devicePollInterval = time.Second
+
+// remember config as the last-known-good one, call after it is applied successfully
+func markLastGood(config Config) error {
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := putDataSource(lastGoodKey, string(data)); err != nil {
+		log.WithError(err).Error("Save last-known-good config failed")
+		return err
+	}
+	return nil
+}
+
+func GetLastGoodConfig() (Config, error) {
+	data, ok := getDataSource(lastGoodKey)
+	if !ok {
+		return Config{}, errors.New("No config has been applied successfully")
+	}
+	var config Config
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		log.WithError(err).Error("Json unmarshall fail")
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// wait up to timeout for the devices in config to appear, returns the missing ones
+func waitForDevices(config Config, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		var missing []string
+		for _, d := range config.Devices {
+			if _, err := findDeviceLink(d); err != nil {
+				missing = append(missing, d.Name)
+			}
+		}
+		if len(missing) == 0 || time.Now().After(deadline) {
+			return missing
+		}
+		time.Sleep(devicePollInterval)
+	}
+}
+
+// BootApply applies the config in data source when the daemon starts, once its devices appear or wait is over.
+// If it fails, the last-known-good config is applied instead, the config in data source is kept for the user to fix
+func BootApply(wait time.Duration) error {
+	config, err := GetConfigFromDs()
+	if err != nil {
+		return err
+	}
+	if missing := waitForDevices(config, wait); len(missing) > 0 {
+		log.WithField("Devices", missing).Warn("Devices did not appear in " + wait.String() + ", apply anyway")
+	}
+
+	applyLock.Lock()
+	defer applyLock.Unlock()
+	log.Info("启动时应用网络配置")
+	before, _ := GetConfigFromSys()
+	err = Apply(config)
+	auditApply("boot", before, err)
+	if err == nil {
+		return markLastGood(config)
+	}
+	log.WithError(err).Error("Boot apply failed")
+
+	lastGood, lgErr := GetLastGoodConfig()
+	if lgErr != nil || reflect.DeepEqual(lastGood, config) {
+		return err
+	}
+	log.Warn("应用上次成功的网络配置")
+	before, _ = GetConfigFromSys()
+	lgErr = Apply(lastGood)
+	auditApply("boot", before, lgErr)
+	if lgErr != nil {
+		log.WithError(lgErr).Error("Apply last-known-good config failed")
+		return lgErr
+	}
+	return errors.New("Applied the last-known-good config instead, because: " + err.Error())
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForDevices(t *testing.T) {
+	config := Config{Devices: []Device{{Name: "lo"}, {Name: "nonexistent0"}}}
+	start := time.Now()
+	assert.Equal(t, []string{"nonexistent0"}, waitForDevices(config, 0))
+	assert.True(t, time.Since(start) < time.Second)
+
+	old := devicePollInterval
+	defer func() { devicePollInterval = old }()
+	devicePollInterval = 10 * time.Millisecond
+	assert.Equal(t, []string{"nonexistent0"}, waitForDevices(config, 50*time.Millisecond))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	assert.Nil(t, waitForDevices(Config{Devices: []Device{{Name: "lo"}}}, time.Minute))
+}
+
+func TestLastGoodConfig(t *testing.T) {
+	defer delete(DataSource, lastGoodKey)
+	delete(DataSource, lastGoodKey)
+	_, err := GetLastGoodConfig()
+	assert.Error(t, err)
+
+	config := Config{Devices: []Device{{Name: "eth0", IpNets: []string{"10.0.0.1/24"}}}}
+	assert.Nil(t, markLastGood(config))
+	lastGood, err := GetLastGoodConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, config, lastGood)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// used when -config is not given, it is fine if the file does not exist
+const defaultConfigFile = "/etc/network_config/netcfg.yaml"
+
+// the daemon's own settings, not the network config, guarded by daemonConfigLock since SIGHUP replaces it
+var (
+	daemonConfig     = defaultDaemonConfig()
+	daemonConfigLock sync.RWMutex
+)
+
+// DaemonConfig is loaded from the config file, then overridden by NETCFG_* environment variables and flags
+type DaemonConfig struct {
+	LogFormat           string   `yaml:"log_format"` // json or text
+	LogLevel            string   `yaml:"log_level"`
+	Listen              []string `yaml:"listen"`
+	SocketMode          string   `yaml:"socket_mode"` // octal, eg 0660
+	AdminOnly           bool     `yaml:"admin_only"`
+	AdminInterface      string   `yaml:"admin_interface"`
+	HostId              string   `yaml:"host_id"`
+	DataSource          string   `yaml:"data_source"` // file to keep the network config in, empty means memory only
+	TLSCert             string   `yaml:"tls_cert"`
+	TLSKey              string   `yaml:"tls_key"`
+	ClientCA            string   `yaml:"client_ca"`
+	TokenFile           string   `yaml:"token_file"`
+	PasswordFile        string   `yaml:"password_file"`
+	CertAuth            bool     `yaml:"cert_auth"`
+	CertSubjects        []string `yaml:"cert_subjects"`
+	RoleFile            string   `yaml:"role_file"`
+	Reconcile           bool     `yaml:"reconcile"`
+	ReconcileInterval   string   `yaml:"reconcile_interval"`
+	ReconcileMaxBackoff string   `yaml:"reconcile_max_backoff"`
+	ApplyStuckTimeout   string   `yaml:"apply_stuck_timeout"`
+	DeviceWait          string   `yaml:"device_wait"`       // how long apply waits for the devices to appear
+	BootApply           bool     `yaml:"boot_apply"`        // apply the config in data source at start
+	BootWaitDevices     string   `yaml:"boot_wait_devices"` // how long boot apply waits for the devices to appear
+	ShutdownTimeout     string   `yaml:"shutdown_timeout"`  // how long to wait for requests and apply on shutdown
+	AuditFile           string   `yaml:"audit_file"`        // empty disables audit
+	AuditMaxSize        int      `yaml:"audit_max_size"`    // megabytes
+	AuditMaxBackups     int      `yaml:"audit_max_backups"`
+	AuditMaxAge         int      `yaml:"audit_max_age"` // days
+}
+
+func defaultDaemonConfig() DaemonConfig {
+	return DaemonConfig{
+		LogFormat:           "json",
+		LogLevel:            "info",
+		Listen:              []string{":9090"},
+		SocketMode:          "0660",
+		AdminInterface:      "eth3",
+		HostId:              "1",
+		ReconcileInterval:   "30s",
+		ReconcileMaxBackoff: "10m",
+		ApplyStuckTimeout:   "5m",
+		DeviceWait:          "10s",
+		BootWaitDevices:     "30s",
+		ShutdownTimeout:     "1m",
+		AuditFile:           "/var/log/network_config/audit.log",
+		AuditMaxSize:        100,
+		AuditMaxBackups:     10,
+	}
+}
+
+// an option can be set by flag -name or environment variable NETCFG_NAME
+type option struct {
+	name   string
+	usage  string
+	isBool bool
+	set    func(c *DaemonConfig, v string) error
+}
+
+func stringOption(name string, usage string, field func(c *DaemonConfig) *string) option {
+	return option{name: name, usage: usage, set: func(c *DaemonConfig, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func boolOption(name string, usage string, field func(c *DaemonConfig) *bool) option {
+	return option{name: name, usage: usage, isBool: true, set: func(c *DaemonConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		*field(c) = b
+		return err
+	}}
+}
+
+func intOption(name string, usage string, field func(c *DaemonConfig) *int) option {
+	return option{name: name, usage: usage, set: func(c *DaemonConfig, v string) error {
+		i, err := strconv.Atoi(v)
+		*field(c) = i
+		return err
+	}}
+}
+
+// comma separated, a list flag given many times is joined
+func listOption(name string, usage string, field func(c *DaemonConfig) *[]string) option {
+	return option{name: name, usage: usage, set: func(c *DaemonConfig, v string) error {
+		*field(c) = strings.Split(v, ",")
+		return nil
+	}}
+}
+
+var daemonOptions = []option{
+	stringOption("log-format", "log format, json or text", func(c *DaemonConfig) *string { return &c.LogFormat }),
+	stringOption("log-level", "log level, eg debug, info, warning, error", func(c *DaemonConfig) *string { return &c.LogLevel }),
+	listOption("listen", "address to listen on, can be given many times, eg: :9090, [::1]:9090, unix:/run/netcfg.sock", func(c *DaemonConfig) *[]string { return &c.Listen }),
+	stringOption("socket-mode", "file mode of unix domain sockets, octal", func(c *DaemonConfig) *string { return &c.SocketMode }),
+	boolOption("admin-only", "only listen on the admin interface's addresses", func(c *DaemonConfig) *bool { return &c.AdminOnly }),
+	stringOption("admin-interface", "the management interface never touched by apply", func(c *DaemonConfig) *string { return &c.AdminInterface }),
+	stringOption("host-id", "host ID reported in config", func(c *DaemonConfig) *string { return &c.HostId }),
+	stringOption("data-source", "file to keep the network config in, empty means memory only", func(c *DaemonConfig) *string { return &c.DataSource }),
+	stringOption("tls-cert", "TLS certificate file, reloaded on SIGHUP", func(c *DaemonConfig) *string { return &c.TLSCert }),
+	stringOption("tls-key", "TLS key file", func(c *DaemonConfig) *string { return &c.TLSKey }),
+	stringOption("client-ca", "CA file to verify client certificates with", func(c *DaemonConfig) *string { return &c.ClientCA }),
+	stringOption("token-file", "bearer token file, one \"token principal\" per line", func(c *DaemonConfig) *string { return &c.TokenFile }),
+	stringOption("password-file", "basic auth file, one \"user:bcrypt-hash\" per line", func(c *DaemonConfig) *string { return &c.PasswordFile }),
+	boolOption("cert-auth", "authenticate by client certificate", func(c *DaemonConfig) *bool { return &c.CertAuth }),
+	listOption("cert-subjects", "comma separated client certificate subjects allowed, empty allows all verified certificates", func(c *DaemonConfig) *[]string { return &c.CertSubjects }),
+	stringOption("role-file", "role file, one \"principal viewer|editor|operator [scopes]\" per line, empty makes everyone operator", func(c *DaemonConfig) *string { return &c.RoleFile }),
+	boolOption("reconcile", "enable the reconciliation loop", func(c *DaemonConfig) *bool { return &c.Reconcile }),
+	stringOption("reconcile-interval", "how often to check drift", func(c *DaemonConfig) *string { return &c.ReconcileInterval }),
+	stringOption("reconcile-max-backoff", "longest interval after repeated failures", func(c *DaemonConfig) *string { return &c.ReconcileMaxBackoff }),
+	stringOption("apply-stuck-timeout", "an apply running longer is reported not ready", func(c *DaemonConfig) *string { return &c.ApplyStuckTimeout }),
+	stringOption("device-wait", "how long apply waits for the devices to appear, the parts on missing ones are applied when they show up", func(c *DaemonConfig) *string { return &c.DeviceWait }),
+	boolOption("boot-apply", "apply the config in data source at start, falling back to the last-known-good one if it fails", func(c *DaemonConfig) *bool { return &c.BootApply }),
+	stringOption("boot-wait-devices", "how long boot apply waits for the devices to appear", func(c *DaemonConfig) *string { return &c.BootWaitDevices }),
+	stringOption("shutdown-timeout", "how long to wait for running requests and apply on SIGTERM", func(c *DaemonConfig) *string { return &c.ShutdownTimeout }),
+	stringOption("audit-file", "append-only audit log of every change, empty disables audit", func(c *DaemonConfig) *string { return &c.AuditFile }),
+	intOption("audit-max-size", "rotate the audit log when it reaches this many megabytes", func(c *DaemonConfig) *int { return &c.AuditMaxSize }),
+	intOption("audit-max-backups", "rotated audit logs to keep, 0 keeps all", func(c *DaemonConfig) *int { return &c.AuditMaxBackups }),
+	intOption("audit-max-age", "days to keep rotated audit logs, 0 keeps all", func(c *DaemonConfig) *int { return &c.AuditMaxAge }),
+}
+
+func envName(name string) string {
+	return "NETCFG_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// optionFlag records the values given on command line, they are applied after the config file and environment
+type optionFlag struct {
+	opt    option
+	values *[]string
+}
+
+func (f optionFlag) String() string   { return "" }
+func (f optionFlag) IsBoolFlag() bool { return f.opt.isBool }
+func (f optionFlag) Set(v string) error {
+	*f.values = append(*f.values, v)
+	return nil
+}
+
+// LoadDaemonConfig parses args, returns whether to print the effective config and exit
+func LoadDaemonConfig(args []string) (DaemonConfig, bool, error) {
+	fs := flag.NewFlagSet("netcfg", flag.ContinueOnError)
+	configFile := fs.String("config", "", "daemon config file in YAML (default "+defaultConfigFile+" if exists)")
+	printConfig := fs.Bool("print-config", false, "print the effective daemon config and exit")
+	values := make([][]string, len(daemonOptions))
+	for i, opt := range daemonOptions {
+		fs.Var(optionFlag{opt, &values[i]}, opt.name, opt.usage+" (env "+envName(opt.name)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return DaemonConfig{}, false, err
+	}
+
+	c := defaultDaemonConfig()
+	file := *configFile
+	if file == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			file = defaultConfigFile
+		}
+	}
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return DaemonConfig{}, false, err
+		}
+		if err := yaml.UnmarshalStrict(data, &c); err != nil {
+			return DaemonConfig{}, false, errors.New("Parse " + file + " failed: " + err.Error())
+		}
+	}
+
+	for i, opt := range daemonOptions {
+		if v := os.Getenv(envName(opt.name)); v != "" {
+			if err := opt.set(&c, v); err != nil {
+				return DaemonConfig{}, false, errors.New("Bad " + envName(opt.name) + ": " + err.Error())
+			}
+		}
+		if len(values[i]) > 0 {
+			if err := opt.set(&c, strings.Join(values[i], ",")); err != nil {
+				return DaemonConfig{}, false, errors.New("Bad -" + opt.name + ": " + err.Error())
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return DaemonConfig{}, false, err
+	}
+	return c, *printConfig, nil
+}
+
+func (c DaemonConfig) Validate() error {
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		return errors.New("log_format should be json or text")
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if len(c.Listen) == 0 {
+		return errors.New("listen can not be empty")
+	}
+	if _, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil {
+		return errors.New("socket_mode should be octal like 0660")
+	}
+	if c.AdminInterface == "" {
+		return errors.New("admin_interface can not be empty")
+	}
+	for name, d := range map[string]string{"reconcile_interval": c.ReconcileInterval, "reconcile_max_backoff": c.ReconcileMaxBackoff, "apply_stuck_timeout": c.ApplyStuckTimeout, "shutdown_timeout": c.ShutdownTimeout} {
+		if duration, err := time.ParseDuration(d); err != nil || duration <= 0 {
+			return errors.New(name + " should be a positive duration like 30s")
+		}
+	}
+	if c.AuditMaxSize <= 0 || c.AuditMaxBackups < 0 || c.AuditMaxAge < 0 {
+		return errors.New("audit_max_size should be positive, audit_max_backups and audit_max_age should not be negative")
+	}
+	for name, d := range map[string]string{"device_wait": c.DeviceWait, "boot_wait_devices": c.BootWaitDevices} {
+		if duration, err := time.ParseDuration(d); err != nil || duration < 0 {
+			return errors.New(name + " should be a duration like 30s")
+		}
+	}
+	if c.BootApply && c.DataSource == "" {
+		return errors.New("boot_apply needs data_source")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls_cert and tls_key should be given together")
+	}
+	if c.ClientCA != "" && c.TLSCert == "" {
+		return errors.New("client_ca needs tls_cert")
+	}
+	if c.CertAuth && c.ClientCA == "" {
+		return errors.New("cert_auth needs client_ca")
+	}
+	return nil
+}
+
+func (c DaemonConfig) socketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+func (c DaemonConfig) duration(d string) time.Duration {
+	duration, _ := time.ParseDuration(d)
+	return duration
+}
+
+func applyLogConfig(c DaemonConfig) {
+	if c.LogFormat == "text" {
+		log.SetFormatter(&log.TextFormatter{})
+	} else {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+	log.SetOutput(os.Stdout)
+	level, err := log.ParseLevel(c.LogLevel)
+	if err != nil {
+		level = log.InfoLevel
+	}
+	log.SetLevel(level)
+}
+
+func currentDaemonConfig() DaemonConfig {
+	daemonConfigLock.RLock()
+	defer daemonConfigLock.RUnlock()
+	return daemonConfig
+}
+
+// the settings only read at start, a reload keeps their old values and returns the names of the changed ones
+func (c *DaemonConfig) keepStartupSettings(old DaemonConfig) []string {
+	var changed []string
+	keep := func(name string, cur interface{}, prev interface{}) {
+		v, p := reflect.ValueOf(cur).Elem(), reflect.ValueOf(prev).Elem()
+		if !reflect.DeepEqual(v.Interface(), p.Interface()) {
+			changed = append(changed, name)
+			v.Set(p)
+		}
+	}
+	keep("listen", &c.Listen, &old.Listen)
+	keep("socket_mode", &c.SocketMode, &old.SocketMode)
+	keep("admin_only", &c.AdminOnly, &old.AdminOnly)
+	keep("data_source", &c.DataSource, &old.DataSource)
+	keep("tls_cert", &c.TLSCert, &old.TLSCert)
+	keep("tls_key", &c.TLSKey, &old.TLSKey)
+	keep("client_ca", &c.ClientCA, &old.ClientCA)
+	return changed
+}
+
+// apply the settings that do not need a restart
+func applyDaemonConfig(c DaemonConfig) {
+	daemonConfigLock.Lock()
+	daemonConfig = c
+	daemonConfigLock.Unlock()
+	applyLogConfig(c)
+	reconciler.Set(c.Reconcile, c.duration(c.ReconcileInterval), c.duration(c.ReconcileMaxBackoff))
+	auditLog.Set(c.AuditFile, c.AuditMaxSize, c.AuditMaxBackups, c.AuditMaxAge)
+}
+
+func init() {
+	applyLogConfig(daemonConfig)
+}
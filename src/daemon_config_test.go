@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDaemonConfigDefault(t *testing.T) {
+	c, printConfig, err := LoadDaemonConfig([]string{"-config", "/dev/null"})
+	assert.Nil(t, err)
+	assert.False(t, printConfig)
+	assert.Equal(t, defaultDaemonConfig(), c)
+	assert.Equal(t, os.FileMode(0660), c.socketMode())
+	assert.Equal(t, 30*time.Second, c.duration(c.ReconcileInterval))
+}
+
+func TestLoadDaemonConfigPrecedence(t *testing.T) {
+	file := writeTempFile(t, "log_level: debug\nhost_id: file\nadmin_interface: eth0\nlisten: [\":8080\"]\n")
+	defer os.Remove(file)
+
+	c, _, err := LoadDaemonConfig([]string{"-config", file})
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", c.LogLevel)
+	assert.Equal(t, "file", c.HostId)
+	assert.Equal(t, []string{":8080"}, c.Listen)
+
+	// environment overrides the file, flags override both
+	os.Setenv("NETCFG_HOST_ID", "env")
+	os.Setenv("NETCFG_ADMIN_ONLY", "true")
+	defer os.Unsetenv("NETCFG_HOST_ID")
+	defer os.Unsetenv("NETCFG_ADMIN_ONLY")
+	c, printConfig, err := LoadDaemonConfig([]string{"-config", file, "-admin-interface", "eth1",
+		"-listen", ":9090", "-listen", "unix:/run/netcfg.sock", "-print-config"})
+	assert.Nil(t, err)
+	assert.True(t, printConfig)
+	assert.Equal(t, "env", c.HostId)
+	assert.True(t, c.AdminOnly)
+	assert.Equal(t, "eth1", c.AdminInterface)
+	assert.Equal(t, []string{":9090", "unix:/run/netcfg.sock"}, c.Listen)
+	assert.Equal(t, "debug", c.LogLevel)
+}
+
+func TestLoadDaemonConfigInvalid(t *testing.T) {
+	file := writeTempFile(t, "log_levle: debug\n")
+	defer os.Remove(file)
+	_, _, err := LoadDaemonConfig([]string{"-config", file})
+	assert.Error(t, err)
+
+	_, _, err = LoadDaemonConfig([]string{"-config", "/nonexistent/netcfg.yaml"})
+	assert.Error(t, err)
+
+	for _, args := range [][]string{
+		{"-log-format", "xml"},
+		{"-log-level", "loud"},
+		{"-socket-mode", "rw"},
+		{"-reconcile-interval", "0s"},
+		{"-tls-cert", "cert.pem"},
+		{"-cert-auth"},
+		{"-admin-only=maybe"},
+		{"-boot-apply"},
+		{"-boot-wait-devices", "-1s"},
+	} {
+		_, _, err := LoadDaemonConfig(append([]string{"-config", "/dev/null"}, args...))
+		assert.Error(t, err, args)
+	}
+}
+
+func TestOpenDataSource(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "datasource")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ds.json")
+	old, oldConfig := DataSource, daemonConfig
+	defer func() { DataSource, daemonConfig = old, oldConfig }()
+
+	DataSource = map[string]string{"network": "{}"}
+	daemonConfig.DataSource = file
+	assert.Nil(t, OpenDataSource(file))
+	_, err := os.Stat(file)
+	assert.Nil(t, err)
+
+	assert.Nil(t, PutToDataSource(Config{HostId: "2"}))
+	DataSource = nil
+	assert.Nil(t, OpenDataSource(file))
+	config, err := GetConfigFromDs()
+	assert.Nil(t, err)
+	assert.Equal(t, "2", config.HostId)
+}
+
+func TestDataSourceFailedWrite(t *testing.T) {
+	old, oldConfig := DataSource, daemonConfig
+	defer func() { DataSource, daemonConfig = old, oldConfig }()
+	DataSource = map[string]string{"network": `{"HostId": "1"}`}
+	daemonConfig.DataSource = "/nonexistent/ds.json"
+
+	assert.Error(t, PutToDataSource(Config{HostId: "2"}))
+	config, err := GetConfigFromDs()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", config.HostId)
+	assert.Error(t, markLastGood(config))
+	_, ok := DataSource[lastGoodKey]
+	assert.False(t, ok)
+}
+
+func TestDataSourceConcurrentChanges(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(Config{Devices: []Device{{Name: "eth0"}}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			AssignIP("eth0", []string{"10.0.0." + strconv.Itoa(i+1) + "/24"})
+		}(i)
+	}
+	wg.Wait()
+	config, _ := GetConfigFromDs()
+	assert.Len(t, config.Devices[0].IpNets, 20)
+}
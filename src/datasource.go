@@ -1,10 +1,99 @@
 package main
 
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
 //mock data source
 func init() {
 	DataSource = make(map[string]string)
-	sysConfig, _ := GetConfigFromSys()
-	PutToDataSource(sysConfig)
 }
 
 var DataSource map[string]string
+
+// guards DataSource and its file
+var dsLock sync.Mutex
+
+// serializes the changes of the stored config, hold it from GetConfigFromDs to PutToDataSource
+var configLock sync.Mutex
+
+// OpenDataSource keeps the data source in file, empty file means memory only. The stored config is
+// loaded if the file exists, otherwise the data source starts from the system's config
+func OpenDataSource(file string) error {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			ds := make(map[string]string)
+			if err := json.Unmarshal(data, &ds); err != nil {
+				log.WithError(err).Error("Parse data source " + file + " failed")
+				return err
+			}
+			dsLock.Lock()
+			DataSource = ds
+			dsLock.Unlock()
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			log.WithError(err).Error("Read data source " + file + " failed")
+			return err
+		}
+	}
+
+	sysConfig, err := GetConfigFromSys()
+	if err != nil {
+		return err
+	}
+	return PutToDataSource(sysConfig)
+}
+
+func getDataSource(key string) (string, bool) {
+	dsLock.Lock()
+	defer dsLock.Unlock()
+	value, ok := DataSource[key]
+	return value, ok
+}
+
+// the value is kept in memory only if it is written to the file
+func putDataSource(key string, value string) error {
+	dsLock.Lock()
+	defer dsLock.Unlock()
+	old, ok := DataSource[key]
+	DataSource[key] = value
+	if err := writeDataSource(); err != nil {
+		if ok {
+			DataSource[key] = old
+		} else {
+			delete(DataSource, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func saveDataSource() error {
+	dsLock.Lock()
+	defer dsLock.Unlock()
+	return writeDataSource()
+}
+
+// write to a temp file then rename, so that a crash never leaves a broken file. Hold dsLock when calling
+func writeDataSource() error {
+	file := currentDaemonConfig().DataSource
+	if file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(DataSource, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	LINK    = "link"
+	ADDRESS = "address"
+
+	ADDED   = "added"   // exists in system but not in database
+	REMOVED = "removed" // exists in database but not in system
+	CHANGED = "changed"
+)
+
+// one difference between the config in database and the system
+type Drift struct {
+	Kind    string // link or address
+	Action  string // added, removed or changed
+	Type    string // device, bond, vlan, bridge, macvlan, ipvlan, vxlan, tunnel or veth
+	Name    string
+	Field   string      `json:",omitempty"`
+	Desired interface{} `json:",omitempty"`
+	Live    interface{} `json:",omitempty"`
+}
+
+// the fields of an interface that we care about when comparing, Index is ignored
+type linkState struct {
+	Type     string
+	Name     string
+	Mode     int
+	ModeName string // the mode of macvlan, ipvlan and tunnel
+	Tag      int
+	Parent   string
+	Devs     []string
+	IpNets   []string
+	// vxlan and tunnel
+	Vni      int
+	Local    string
+	Remote   string
+	Group    string
+	Port     int
+	Dev      string
+	Learning string
+	Key      int
+	Ttl      int
+	Peer     string // veth, when in the host namespace
+	peerEnd  bool   // a veth end known from the entry of its peer, its own settings are not known
+	// only compared when set in database
+	Mtu          int
+	HardwareAddr string
+	Alias        string
+	State        string // always compared, empty is up
+}
+
+// the fields changed in place instead of rebuilding the interface
+var linkSettingFields = map[string]bool{"Mtu": true, "HardwareAddr": true, "Alias": true, "State": true}
+
+// compare the config in database with the system
+func GetDrift() ([]Drift, error) {
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return nil, err
+	}
+
+	sysConfig, err := GetConfigFromSys()
+	if err != nil {
+		log.WithError(err).Error("Get config from system failed")
+		return nil, err
+	}
+	drifts := Diff(resolveDevs(userConfig), sysConfig)
+	driftCount.Set(float64(len(drifts)))
+	return drifts, nil
+}
+
+// Diff returns what should be done on live to make it same as desired,
+// link-local addresses and the addresses of admin interface and lo are ignored
+func Diff(desired Config, live Config) []Drift {
+	drifts := []Drift{}
+	desiredLinks, liveLinks := linkStates(desired), linkStates(live)
+
+	var names []string
+	for name := range desiredLinks {
+		names = append(names, name)
+	}
+	for name := range liveLinks {
+		if _, ok := desiredLinks[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d, inDesired := desiredLinks[name]
+		l, inLive := liveLinks[name]
+		switch {
+		case inDesired && !inLive:
+			drifts = append(drifts, Drift{Kind: LINK, Action: REMOVED, Type: d.Type, Name: name})
+		case !inDesired && inLive:
+			drifts = append(drifts, Drift{Kind: LINK, Action: ADDED, Type: l.Type, Name: name})
+		default:
+			drifts = append(drifts, diffLink(d, l)...)
+			drifts = append(drifts, diffAddr(d, l)...)
+		}
+	}
+	return drifts
+}
+
+func diffLink(d linkState, l linkState) []Drift {
+	var drifts []Drift
+	changed := func(field string, desired, live interface{}) {
+		drifts = append(drifts, Drift{Kind: LINK, Action: CHANGED, Type: d.Type, Name: d.Name, Field: field, Desired: desired, Live: live})
+	}
+
+	if d.Type != l.Type {
+		changed("Type", d.Type, l.Type)
+		return drifts
+	}
+	if d.Mode != l.Mode {
+		changed("Mode", d.Mode, l.Mode)
+	}
+	if d.ModeName != l.ModeName {
+		changed("Mode", d.ModeName, l.ModeName)
+	}
+	if d.Tag != l.Tag {
+		changed("Tag", d.Tag, l.Tag)
+	}
+	if d.Parent != l.Parent {
+		changed("Parent", d.Parent, l.Parent)
+	}
+	if !reflect.DeepEqual(d.Devs, l.Devs) {
+		changed("Devs", d.Devs, l.Devs)
+	}
+	if d.Vni != l.Vni {
+		changed("Vni", d.Vni, l.Vni)
+	}
+	if d.Local != l.Local {
+		changed("Local", d.Local, l.Local)
+	}
+	if d.Remote != l.Remote {
+		changed("Remote", d.Remote, l.Remote)
+	}
+	if d.Group != l.Group {
+		changed("Group", d.Group, l.Group)
+	}
+	if d.Port != l.Port {
+		changed("Port", d.Port, l.Port)
+	}
+	if d.Dev != l.Dev {
+		changed("Dev", d.Dev, l.Dev)
+	}
+	if d.Learning != l.Learning {
+		changed("Learning", d.Learning, l.Learning)
+	}
+	if d.Key != l.Key {
+		changed("Key", d.Key, l.Key)
+	}
+	if d.Ttl != l.Ttl {
+		changed("Ttl", d.Ttl, l.Ttl)
+	}
+	if d.Peer != l.Peer {
+		changed("Peer", d.Peer, l.Peer)
+	}
+	if l.peerEnd {
+		return drifts
+	}
+	if d.Mtu != 0 && d.Mtu != l.Mtu {
+		changed("Mtu", d.Mtu, l.Mtu)
+	}
+	if d.HardwareAddr != "" && !sameMac(d.HardwareAddr, l.HardwareAddr) {
+		changed("HardwareAddr", d.HardwareAddr, l.HardwareAddr)
+	}
+	if d.Alias != "" && d.Alias != l.Alias {
+		changed("Alias", d.Alias, l.Alias)
+	}
+	if d.State != l.State && d.Name != getAdminInterface() && d.Name != "lo" {
+		changed("State", d.State, l.State)
+	}
+	return drifts
+}
+
+func diffAddr(d linkState, l linkState) []Drift {
+	var drifts []Drift
+	if d.Name == getAdminInterface() || d.Name == "lo" {
+		return drifts
+	}
+
+	desiredAddrs, liveAddrs := make(map[string]bool), make(map[string]bool)
+	for _, ipNet := range d.IpNets {
+		desiredAddrs[ipNet] = true
+	}
+	for _, ipNet := range l.IpNets {
+		liveAddrs[ipNet] = true
+	}
+
+	for _, ipNet := range d.IpNets {
+		if !liveAddrs[ipNet] {
+			drifts = append(drifts, Drift{Kind: ADDRESS, Action: REMOVED, Type: d.Type, Name: d.Name, Desired: ipNet})
+		}
+	}
+	for _, ipNet := range l.IpNets {
+		if !desiredAddrs[ipNet] {
+			drifts = append(drifts, Drift{Kind: ADDRESS, Action: ADDED, Type: d.Type, Name: d.Name, Live: ipNet})
+		}
+	}
+	return drifts
+}
+
+func linkStates(config Config) map[string]linkState {
+	m := make(map[string]linkState)
+	for _, d := range config.Devices {
+		m[d.Name] = linkState{Type: DEVICE, Name: d.Name, IpNets: normalizeIPs(d.IpNets),
+			Mtu: d.Mtu, HardwareAddr: d.HardwareAddr, Alias: d.Alias, State: orUp(d.State)}
+	}
+	for _, b := range config.Bonds {
+		m[b.Name] = linkState{Type: BOND, Name: b.Name, Mode: b.Mode, Devs: sortedDevs(b.Devs), IpNets: normalizeIPs(b.IpNets),
+			Mtu: b.Mtu, HardwareAddr: b.HardwareAddr, Alias: b.Alias, State: orUp(b.State)}
+		// the bond decides the mac of its slaves
+		for _, dev := range b.Devs {
+			if s, ok := m[dev]; ok {
+				s.HardwareAddr = ""
+				m[dev] = s
+			}
+		}
+	}
+	for _, v := range config.Vlans {
+		m[v.Name] = linkState{Type: VLAN, Name: v.Name, Tag: v.Tag, Parent: v.Parent, IpNets: normalizeIPs(v.IpNets),
+			Mtu: v.Mtu, HardwareAddr: v.HardwareAddr, Alias: v.Alias, State: orUp(v.State)}
+	}
+	for _, br := range config.Bridges {
+		m[br.Name] = linkState{Type: BRIDGE, Name: br.Name, Devs: sortedDevs(br.Devs), IpNets: normalizeIPs(br.IpNets),
+			Mtu: br.Mtu, HardwareAddr: br.HardwareAddr, Alias: br.Alias, State: orUp(br.State)}
+	}
+	for _, mv := range config.Macvlans {
+		m[mv.Name] = linkState{Type: MACVLAN, Name: mv.Name, ModeName: orDefault(mv.Mode, macvlanModes[0].Name), Parent: mv.Parent, IpNets: normalizeIPs(mv.IpNets),
+			Mtu: mv.Mtu, HardwareAddr: mv.HardwareAddr, Alias: mv.Alias, State: orUp(mv.State)}
+	}
+	for _, x := range config.Vxlans {
+		m[x.Name] = linkState{Type: VXLAN, Name: x.Name, Vni: x.Vni, Local: normalizeIP(x.Local), Remote: normalizeIP(x.Remote), Group: normalizeIP(x.Group),
+			Port: vxlanPort(x.Port), Dev: x.Dev, Learning: orDefault(x.Learning, "on"), IpNets: normalizeIPs(x.IpNets),
+			Mtu: x.Mtu, HardwareAddr: x.HardwareAddr, Alias: x.Alias, State: orUp(x.State)}
+	}
+	for _, tun := range config.Tunnels {
+		m[tun.Name] = linkState{Type: TUNNEL, Name: tun.Name, ModeName: tun.Mode, Local: normalizeIP(tun.Local), Remote: normalizeIP(tun.Remote),
+			Key: tun.Key, Ttl: tun.Ttl, Dev: tun.Dev, IpNets: normalizeIPs(tun.IpNets),
+			Mtu: tun.Mtu, HardwareAddr: tun.HardwareAddr, Alias: tun.Alias, State: orUp(tun.State)}
+	}
+	// a pair in the host namespace is listed once by either end, the other one is added here
+	for _, v := range config.Veths {
+		s := linkState{Type: VETH, Name: v.Name, IpNets: normalizeIPs(v.IpNets),
+			Mtu: v.Mtu, HardwareAddr: v.HardwareAddr, Alias: v.Alias, State: orUp(v.State)}
+		if peerInHost(v) {
+			s.Peer = v.Peer
+			if v.Peer != "" {
+				m[v.Peer] = linkState{Type: VETH, Name: v.Peer, Peer: v.Name, peerEnd: true, IpNets: normalizeIPs(v.PeerIpNets), Mtu: v.Mtu, State: orUp(v.State)}
+			}
+		}
+		m[v.Name] = s
+	}
+	for _, iv := range config.Ipvlans {
+		m[iv.Name] = linkState{Type: IPVLAN, Name: iv.Name, ModeName: orDefault(iv.Mode, ipvlanModes[0].Name), Parent: iv.Parent, IpNets: normalizeIPs(iv.IpNets),
+			Mtu: iv.Mtu, Alias: iv.Alias, State: orUp(iv.State)}
+	}
+	return m
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func sortedDevs(devs []string) []string {
+	sorted := append([]string{}, devs...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// eg: 2001:DB8::1/64 and 2001:db8::1/64 are the same address, fe80::1/64 is dropped
+func normalizeIPs(ipNets []string) []string {
+	var normalized []string
+	for _, ipNet := range ipNets {
+		addr, err := netlink.ParseAddr(ipNet)
+		if err != nil {
+			normalized = append(normalized, ipNet)
+			continue
+		}
+		if isLinkLocal(addr.IP) {
+			continue
+		}
+		normalized = append(normalized, addr.IPNet.String())
+	}
+	return normalized
+}
+
+// eg: 2001:DB8::1 and 2001:db8::1 are the same address
+func normalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+func isLinkLocal(ip net.IP) bool {
+	return ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
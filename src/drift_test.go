@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	desired := Config{
+		Devices: []Device{{Index: 2, Name: "eth0"}, {Index: 3, Name: "eth1", IpNets: []string{"1.1.1.1/24"}}, {Index: 4, Name: "eth2"}},
+		Bonds:   []Bond{{Name: "bond0", Mode: 4, Devs: []string{"eth2", "eth0"}}},
+		Vlans:   []Vlan{{Name: "vlan0", Tag: 100, Parent: "eth1"}},
+	}
+	live := Config{
+		Devices: []Device{{Index: 12, Name: "eth0"}, {Index: 13, Name: "eth1", IpNets: []string{"2.2.2.2/24", "fe80::1/64"}}, {Index: 14, Name: "eth2"}},
+		Bonds:   []Bond{{Index: 15, Name: "bond0", Mode: 1, Devs: []string{"eth0", "eth2"}}},
+		Bridges: []Bridge{{Index: 16, Name: "br0"}},
+	}
+
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: BOND, Name: "bond0", Field: "Mode", Desired: 4, Live: 1},
+		{Kind: LINK, Action: ADDED, Type: BRIDGE, Name: "br0"},
+		{Kind: ADDRESS, Action: REMOVED, Type: DEVICE, Name: "eth1", Desired: "1.1.1.1/24"},
+		{Kind: ADDRESS, Action: ADDED, Type: DEVICE, Name: "eth1", Live: "2.2.2.2/24"},
+		{Kind: LINK, Action: REMOVED, Type: VLAN, Name: "vlan0"},
+	}, Diff(desired, live))
+
+	assert.Equal(t, []Drift{}, Diff(desired, desired))
+}
+
+func TestDiffIgnoreAdmin(t *testing.T) {
+	desired := Config{Devices: []Device{{Name: getAdminInterface()}, {Name: "lo"}}}
+	live := Config{Devices: []Device{{Name: getAdminInterface(), IpNets: []string{"192.168.26.61/24"}}, {Name: "lo", IpNets: []string{"127.0.0.1/8"}}}}
+	assert.Equal(t, []Drift{}, Diff(desired, live))
+}
+
+func TestDiffLinkSettings(t *testing.T) {
+	desired := Config{
+		Devices: []Device{{Name: "eth0", HardwareAddr: "52:54:00:12:34:56"}, {Name: "eth1", Mtu: 9000, Alias: "uplink"}},
+		Bonds:   []Bond{{Name: "bond0", Devs: []string{"eth0"}, HardwareAddr: "52:54:00:AB:CD:EF"}},
+	}
+	live := Config{
+		Devices: []Device{{Name: "eth0", Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:ef"}, {Name: "eth1", Mtu: 1500, Alias: "uplink"}},
+		Bonds:   []Bond{{Name: "bond0", Devs: []string{"eth0"}, Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:ef"}},
+	}
+	// the mac of a bond slave and the unset fields are not compared
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: DEVICE, Name: "eth1", Field: "Mtu", Desired: 9000, Live: 1500},
+	}, Diff(desired, live))
+}
+
+func TestDiffMacvlan(t *testing.T) {
+	desired := Config{
+		Macvlans: []Macvlan{{Name: "mv0", Parent: "eth0"}, {Name: "mv1", Parent: "eth0", Mode: "vepa"}},
+		Ipvlans:  []Ipvlan{{Name: "ipv0", Parent: "eth0", Mode: "l3"}},
+	}
+	live := Config{
+		Macvlans: []Macvlan{{Name: "mv0", Parent: "eth0", Mode: "bridge"}, {Name: "mv1", Parent: "eth0", Mode: "bridge"}},
+		Ipvlans:  []Ipvlan{{Name: "ipv0", Parent: "eth1", Mode: "l3"}},
+	}
+	// bridge is the default mode
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: IPVLAN, Name: "ipv0", Field: "Parent", Desired: "eth0", Live: "eth1"},
+		{Kind: LINK, Action: CHANGED, Type: MACVLAN, Name: "mv1", Field: "Mode", Desired: "vepa", Live: "bridge"},
+	}, Diff(desired, live))
+}
+
+func TestDiffVxlan(t *testing.T) {
+	desired := Config{Vxlans: []Vxlan{{Name: "vxlan100", Vni: 100, Remote: "2001:DB8::2"}, {Name: "vxlan200", Vni: 200, Learning: "off"}}}
+	live := Config{Vxlans: []Vxlan{{Name: "vxlan100", Vni: 100, Remote: "2001:db8::2", Port: 4789, Learning: "on"}, {Name: "vxlan200", Vni: 200, Port: 8472, Learning: "off"}}}
+	// 4789 and learning on are the defaults
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: VXLAN, Name: "vxlan200", Field: "Port", Desired: 4789, Live: 8472},
+	}, Diff(desired, live))
+}
+
+func TestDiffTunnel(t *testing.T) {
+	desired := Config{Tunnels: []Tunnel{{Name: "gre1", Mode: GRE, Remote: "192.0.2.1", Key: 7}}}
+	live := Config{Tunnels: []Tunnel{{Name: "gre1", Mode: GRE, Remote: "192.0.2.1", Key: 8}, {Name: "sit1", Mode: SIT, Remote: "192.0.2.2"}}}
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: TUNNEL, Name: "gre1", Field: "Key", Desired: 7, Live: 8},
+		{Kind: LINK, Action: ADDED, Type: TUNNEL, Name: "sit1"},
+	}, Diff(desired, live))
+}
+
+func TestDiffState(t *testing.T) {
+	desired := Config{Devices: []Device{{Name: "eth0"}, {Name: "eth1", State: DOWN}, {Name: "lo", State: DOWN}}}
+	live := Config{Devices: []Device{{Name: "eth0", State: DOWN}, {Name: "eth1", State: DOWN}, {Name: "lo", State: UP}}}
+	// empty is up
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: DEVICE, Name: "eth0", Field: "State", Desired: UP, Live: DOWN},
+	}, Diff(desired, live))
+}
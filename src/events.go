@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	LINK_ADDED      = "link.added"
+	LINK_REMOVED    = "link.removed"
+	LINK_UP         = "link.up" // administrative state
+	LINK_DOWN       = "link.down"
+	CARRIER_UP      = "carrier.up"
+	CARRIER_DOWN    = "carrier.down"
+	LINK_MASTER     = "link.master" // enslaved to a bond/bridge or released, Detail is the master's name
+	ADDR_ADDED      = "address.added"
+	ADDR_REMOVED    = "address.removed"
+	CONFIG_CHANGED  = "config.changed"
+	APPLY_STARTED   = "apply.started"
+	APPLY_FINISHED  = "apply.finished" // Detail is the error, empty means success
+	DRIFT_CORRECTED = "drift.corrected"
+)
+
+// a slow subscriber loses events instead of blocking the others
+const eventBuffer = 64
+
+var events = NewEventHub()
+
+type Event struct {
+	Time   time.Time
+	Type   string
+	Name   string      `json:",omitempty"`
+	Detail interface{} `json:",omitempty"`
+}
+
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[chan Event]bool)}
+}
+
+func (h *EventHub) Subscribe() chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan Event, eventBuffer)
+	h.subs[ch] = true
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+func (h *EventHub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// the state of a link we publish events for
+type linkSnapshot struct {
+	Name    string
+	Up      bool
+	Carrier bool
+	Master  int
+}
+
+func newLinkSnapshot(attrs *netlink.LinkAttrs) linkSnapshot {
+	return linkSnapshot{
+		Name:    attrs.Name,
+		Up:      attrs.Flags&net.FlagUp != 0,
+		Carrier: attrs.OperState == netlink.OperUp,
+		Master:  attrs.MasterIndex,
+	}
+}
+
+// events of a link changing from old to cur, old is nil for a new link
+func linkEvents(old *linkSnapshot, cur linkSnapshot, names map[int]string) []Event {
+	if old == nil {
+		return []Event{{Type: LINK_ADDED, Name: cur.Name}}
+	}
+
+	var es []Event
+	if old.Up != cur.Up {
+		if cur.Up {
+			es = append(es, Event{Type: LINK_UP, Name: cur.Name})
+		} else {
+			es = append(es, Event{Type: LINK_DOWN, Name: cur.Name})
+		}
+	}
+	if old.Carrier != cur.Carrier {
+		if cur.Carrier {
+			es = append(es, Event{Type: CARRIER_UP, Name: cur.Name})
+		} else {
+			es = append(es, Event{Type: CARRIER_DOWN, Name: cur.Name})
+		}
+	}
+	if old.Master != cur.Master {
+		es = append(es, Event{Type: LINK_MASTER, Name: cur.Name, Detail: names[cur.Master]})
+	}
+	return es
+}
+
+// how long to wait before subscribing again after netlink closed a subscription
+var resubscribeDelay = time.Second
+
+// publish the kernel's link and address changes until failed to subscribe, netlink closes
+// the subscriptions on a receive error and they are made again
+func WatchNetlink() {
+	for watchNetlink() {
+		log.Warn("Netlink subscription closed, subscribe again")
+		time.Sleep(resubscribeDelay)
+	}
+}
+
+// false if failed to subscribe, true when a subscription is closed
+func watchNetlink() bool {
+	// closing done ends both subscriptions, their goroutines exit after the updates being sent are read
+	done := make(chan struct{})
+	linkCh := make(chan netlink.LinkUpdate)
+	addrCh := make(chan netlink.AddrUpdate)
+	if err := netlink.LinkSubscribe(linkCh, done); err != nil {
+		log.WithError(err).Error("Subscribe link update failed")
+		close(done)
+		return false
+	}
+	if err := netlink.AddrSubscribe(addrCh, done); err != nil {
+		log.WithError(err).Error("Subscribe address update failed")
+		close(done)
+		for range linkCh {
+		}
+		return false
+	}
+	defer func() {
+		close(done)
+		for range linkCh {
+		}
+		for range addrCh {
+		}
+	}()
+
+	links := make(map[int]linkSnapshot)
+	if list, err := netlink.LinkList(); err == nil {
+		for _, link := range list {
+			links[link.Attrs().Index] = newLinkSnapshot(link.Attrs())
+		}
+	}
+	names := func() map[int]string {
+		m := make(map[int]string)
+		for index, l := range links {
+			m[index] = l.Name
+		}
+		return m
+	}
+
+	for {
+		select {
+		case update, ok := <-linkCh:
+			if !ok {
+				return true
+			}
+			attrs := update.Link.Attrs()
+			if update.Header.Type == syscall.RTM_DELLINK {
+				delete(links, attrs.Index)
+				events.Publish(Event{Type: LINK_REMOVED, Name: attrs.Name})
+				continue
+			}
+			cur := newLinkSnapshot(attrs)
+			var es []Event
+			if old, ok := links[attrs.Index]; ok {
+				es = linkEvents(&old, cur, names())
+			} else {
+				es = linkEvents(nil, cur, names())
+			}
+			links[attrs.Index] = cur
+			for _, e := range es {
+				events.Publish(e)
+			}
+		case update, ok := <-addrCh:
+			if !ok {
+				return true
+			}
+			e := Event{Type: ADDR_REMOVED, Name: links[update.LinkIndex].Name, Detail: update.LinkAddress.String()}
+			if update.NewAddr {
+				e.Type = ADDR_ADDED
+			}
+			events.Publish(e)
+		}
+	}
+}
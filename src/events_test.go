@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	ch1, ch2 := hub.Subscribe(), hub.Subscribe()
+	hub.Publish(Event{Type: CONFIG_CHANGED})
+	e := <-ch1
+	assert.Equal(t, CONFIG_CHANGED, e.Type)
+	assert.False(t, e.Time.IsZero())
+	assert.Equal(t, CONFIG_CHANGED, (<-ch2).Type)
+
+	hub.Unsubscribe(ch2)
+	hub.Publish(Event{Type: APPLY_STARTED})
+	assert.Equal(t, APPLY_STARTED, (<-ch1).Type)
+	assert.Equal(t, 0, len(ch2))
+
+	// never block on a full subscriber
+	for i := 0; i < eventBuffer+1; i++ {
+		hub.Publish(Event{Type: APPLY_FINISHED})
+	}
+	assert.Equal(t, eventBuffer, len(ch1))
+}
+
+func TestLinkEvents(t *testing.T) {
+	names := map[int]string{5: "bond0"}
+	cur := linkSnapshot{Name: "eth0", Up: true, Carrier: true, Master: 5}
+	assert.Equal(t, []Event{{Type: LINK_ADDED, Name: "eth0"}}, linkEvents(nil, cur, names))
+
+	old := linkSnapshot{Name: "eth0"}
+	assert.Equal(t, []Event{
+		{Type: LINK_UP, Name: "eth0"},
+		{Type: CARRIER_UP, Name: "eth0"},
+		{Type: LINK_MASTER, Name: "eth0", Detail: "bond0"},
+	}, linkEvents(&old, cur, names))
+
+	assert.Equal(t, []Event{{Type: CARRIER_DOWN, Name: "eth0"}}, linkEvents(&cur, linkSnapshot{Name: "eth0", Up: true, Master: 5}, names))
+	assert.Nil(t, linkEvents(&cur, cur, names))
+}
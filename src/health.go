@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+var applyState ApplyState
+
+// ApplyState records the running and the last finished apply
+type ApplyState struct {
+	mu           sync.Mutex
+	running      bool
+	started      time.Time
+	lastFinished time.Time
+	lastErr      error
+}
+
+func (s *ApplyState) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+	s.started = time.Now()
+}
+
+func (s *ApplyState) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.lastFinished = time.Now()
+	s.lastErr = err
+}
+
+// stuck if running longer than timeout
+func (s *ApplyState) check(timeout time.Duration) (stuck error, last error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running && time.Since(s.started) > timeout {
+		stuck = errors.New("Apply has been running since " + s.started.Format(time.RFC3339))
+	}
+	return stuck, s.lastErr
+}
+
+type Check struct {
+	Name    string
+	Ok      bool
+	Message string `json:",omitempty"`
+}
+
+// check everything the daemon needs to serve, drift is only checked when reconciliation is enabled
+func Readiness() ([]Check, bool) {
+	var checks []Check
+	ready := true
+	add := func(name string, err error) {
+		c := Check{Name: name, Ok: err == nil}
+		if err != nil {
+			c.Message = err.Error()
+			ready = false
+		}
+		checks = append(checks, c)
+	}
+
+	_, err := GetConfigFromDs()
+	add("datasource", err)
+
+	_, err = netlink.LinkList()
+	add("netlink", err)
+
+	c := currentDaemonConfig()
+	stuck, last := applyState.check(c.duration(c.ApplyStuckTimeout))
+	add("apply_stuck", stuck)
+	add("last_apply", last)
+
+	if reconciler.Status().Enabled {
+		drifts, err := GetDrift()
+		if err == nil && len(drifts) > 0 {
+			err = errors.New("System drifted from database")
+		}
+		add("drift", err)
+	}
+	return checks, ready
+}
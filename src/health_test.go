@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyState(t *testing.T) {
+	var s ApplyState
+	stuck, last := s.check(time.Minute)
+	assert.Nil(t, stuck)
+	assert.Nil(t, last)
+
+	s.start()
+	stuck, _ = s.check(time.Minute)
+	assert.Nil(t, stuck)
+	stuck, _ = s.check(-time.Second)
+	assert.Error(t, stuck)
+
+	s.finish(errors.New("Build bond fail"))
+	stuck, last = s.check(-time.Second)
+	assert.Nil(t, stuck)
+	assert.EqualError(t, last, "Build bond fail")
+
+	s.start()
+	s.finish(nil)
+	_, last = s.check(time.Minute)
+	assert.Nil(t, last)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// devices in the config that were missing at the last apply, their parts are applied when they show up
+var pending = &PendingDevices{names: make(map[string]bool)}
+
+type PendingDevices struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (p *PendingDevices) Set(names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names = make(map[string]bool)
+	for _, name := range names {
+		p.names[name] = true
+	}
+	pendingDevices.Set(float64(len(p.names)))
+}
+
+// returns whether name was pending
+func (p *PendingDevices) Remove(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.names[name] {
+		return false
+	}
+	delete(p.names, name)
+	pendingDevices.Set(float64(len(p.names)))
+	return true
+}
+
+func (p *PendingDevices) Has(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.names[name]
+}
+
+func (p *PendingDevices) List() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withoutDevices removes the missing devices from config along with what can not be built without them:
+// their bond slaves and bridge ports, and the vlans, vxlans, tunnels, macvlans and ipvlans on them. Bonds and bridges are kept with the other members
+func withoutDevices(config Config, missing []string) Config {
+	removed := make(map[string]bool)
+	for _, name := range missing {
+		removed[name] = true
+	}
+	present := func(devs []string) []string {
+		var kept []string
+		for _, dev := range devs {
+			if !removed[dev] {
+				kept = append(kept, dev)
+			}
+		}
+		return kept
+	}
+
+	c := Config{HostId: config.HostId}
+	for _, d := range config.Devices {
+		if !removed[d.Name] {
+			c.Devices = append(c.Devices, d)
+		}
+	}
+	for _, b := range config.Bonds {
+		b.Devs = present(b.Devs)
+		c.Bonds = append(c.Bonds, b)
+	}
+	for _, v := range config.Vlans {
+		if removed[v.Parent] {
+			removed[v.Name] = true
+			continue
+		}
+		c.Vlans = append(c.Vlans, v)
+	}
+	for _, x := range config.Vxlans {
+		if removed[x.Dev] {
+			removed[x.Name] = true
+			continue
+		}
+		c.Vxlans = append(c.Vxlans, x)
+	}
+	for _, tun := range config.Tunnels {
+		if removed[tun.Dev] {
+			removed[tun.Name] = true
+			continue
+		}
+		c.Tunnels = append(c.Tunnels, tun)
+	}
+	// veths do not need any device, only the ones with an end named as a missing device are dropped
+	for _, v := range config.Veths {
+		if removed[v.Name] || peerInHost(v) && removed[v.Peer] {
+			removed[v.Name] = true
+			if peerInHost(v) {
+				removed[v.Peer] = true
+			}
+			continue
+		}
+		c.Veths = append(c.Veths, v)
+	}
+	for _, br := range config.Bridges {
+		br.Devs = present(br.Devs)
+		c.Bridges = append(c.Bridges, br)
+	}
+	for _, m := range config.Macvlans {
+		if !removed[m.Parent] {
+			c.Macvlans = append(c.Macvlans, m)
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if !removed[i.Parent] {
+			c.Ipvlans = append(c.Ipvlans, i)
+		}
+	}
+	return c
+}
+
+// dev and the links built on it: bonds and bridges containing it and vlans, vxlans, tunnels, macvlans and ipvlans on it, recursively
+func dependents(config Config, dev string) map[string]bool {
+	names := map[string]bool{dev: true}
+	containsAny := func(devs []string) bool {
+		for _, d := range devs {
+			if names[d] {
+				return true
+			}
+		}
+		return false
+	}
+	for changed := true; changed; {
+		changed = false
+		add := func(name string, depends bool) {
+			if depends && !names[name] {
+				names[name] = true
+				changed = true
+			}
+		}
+		for _, b := range config.Bonds {
+			add(b.Name, containsAny(b.Devs))
+		}
+		for _, v := range config.Vlans {
+			add(v.Name, names[v.Parent])
+		}
+		for _, x := range config.Vxlans {
+			add(x.Name, names[x.Dev])
+		}
+		for _, tun := range config.Tunnels {
+			add(tun.Name, names[tun.Dev])
+		}
+		for _, br := range config.Bridges {
+			add(br.Name, containsAny(br.Devs))
+		}
+		for _, m := range config.Macvlans {
+			add(m.Name, names[m.Parent])
+		}
+		for _, i := range config.Ipvlans {
+			add(i.Name, names[i.Parent])
+		}
+	}
+	return names
+}
+
+// apply the pending parts of devices when they show up
+func WatchHotplug() {
+	ch := events.Subscribe()
+	// the ones showing up before watching
+	applyAppeared()
+	for e := range ch {
+		if e.Type == LINK_ADDED {
+			applyAppeared()
+		}
+	}
+}
+
+// a new link may be a pending device under another name, when the device has a match
+func applyAppeared() {
+	if len(pending.List()) == 0 {
+		return
+	}
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		return
+	}
+	for _, d := range userConfig.Devices {
+		if !pending.Has(d.Name) {
+			continue
+		}
+		if _, err := findDeviceLink(d); err == nil {
+			applyPending(d.Name)
+		}
+	}
+}
+
+// apply the drifts of dev and the links built on it
+func applyPending(dev string) error {
+	applyLock.Lock()
+	defer applyLock.Unlock()
+	// another apply may have handled it
+	if !pending.Remove(dev) {
+		return nil
+	}
+
+	log.WithField("Device", dev).Info("设备出现,应用相关的网络配置")
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		return err
+	}
+	userConfig = withoutDevices(resolveDevs(userConfig), pending.List())
+	before, err := GetConfigFromSys()
+	if err != nil {
+		return err
+	}
+	if err := renameDevices(userConfig.Devices); err != nil {
+		auditApply("hotplug", before, err)
+		return err
+	}
+	sysConfig, err := GetConfigFromSys()
+	if err != nil {
+		return err
+	}
+
+	affected := dependents(userConfig, dev)
+	var drifts []Drift
+	for _, d := range Diff(userConfig, sysConfig) {
+		if affected[d.Name] {
+			drifts = append(drifts, d)
+		}
+	}
+	err = applyDrift(userConfig, drifts)
+	auditApply("hotplug", before, err)
+	if err != nil {
+		log.WithError(err).WithField("Device", dev).Error("Apply pending config failed")
+	}
+	return err
+}
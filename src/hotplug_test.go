@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var hotplugConfig = Config{
+	Devices: []Device{{Name: "eth0"}, {Name: "eth1"}, {Name: "eth2", IpNets: []string{"10.0.2.1/24"}}},
+	Bonds:   []Bond{{Name: "bond0", Devs: []string{"eth0", "eth2"}}},
+	Vlans:   []Vlan{{Name: "vlan100", Tag: 100, Parent: "eth2"}, {Name: "vlan200", Tag: 200, Parent: "bond0"}},
+	Bridges: []Bridge{{Name: "br0", Devs: []string{"eth1", "vlan100"}}, {Name: "br1", Devs: []string{"vlan200"}}},
+}
+
+func TestWithoutDevices(t *testing.T) {
+	c := withoutDevices(hotplugConfig, []string{"eth2"})
+	assert.Equal(t, []Device{{Name: "eth0"}, {Name: "eth1"}}, c.Devices)
+	assert.Equal(t, []Bond{{Name: "bond0", Devs: []string{"eth0"}}}, c.Bonds)
+	assert.Equal(t, []Vlan{{Name: "vlan200", Tag: 200, Parent: "bond0"}}, c.Vlans)
+	assert.Equal(t, []Bridge{{Name: "br0", Devs: []string{"eth1"}}, {Name: "br1", Devs: []string{"vlan200"}}}, c.Bridges)
+	// the original is not changed
+	assert.Equal(t, []string{"eth0", "eth2"}, hotplugConfig.Bonds[0].Devs)
+
+	assert.Equal(t, hotplugConfig, withoutDevices(hotplugConfig, nil))
+
+	config := Config{
+		Devices: []Device{{Name: "eth0"}},
+		Veths:   []Veth{{Name: "veth0", Peer: "veth1"}, {Name: "veth2", Peer: "veth3"}},
+		Bridges: []Bridge{{Name: "br0", Devs: []string{"eth0", "veth1", "veth3"}}},
+	}
+	c = withoutDevices(config, []string{"eth0"})
+	assert.Equal(t, config.Veths, c.Veths)
+	assert.Equal(t, []Bridge{{Name: "br0", Devs: []string{"veth1", "veth3"}}}, c.Bridges)
+	c = withoutDevices(config, []string{"veth3"})
+	assert.Equal(t, []Veth{{Name: "veth0", Peer: "veth1"}}, c.Veths)
+	assert.Equal(t, []Bridge{{Name: "br0", Devs: []string{"eth0", "veth1"}}}, c.Bridges)
+}
+
+func TestDependents(t *testing.T) {
+	assert.Equal(t, map[string]bool{"eth2": true, "bond0": true, "vlan100": true, "vlan200": true, "br0": true, "br1": true}, dependents(hotplugConfig, "eth2"))
+	assert.Equal(t, map[string]bool{"eth1": true, "br0": true}, dependents(hotplugConfig, "eth1"))
+}
+
+func TestPendingDevices(t *testing.T) {
+	p := &PendingDevices{names: make(map[string]bool)}
+	p.Set([]string{"eth9", "eth8"})
+	assert.Equal(t, []string{"eth8", "eth9"}, p.List())
+	assert.True(t, p.Has("eth9"))
+	assert.True(t, p.Remove("eth9"))
+	assert.False(t, p.Remove("eth9"))
+	assert.Equal(t, []string{"eth8"}, p.List())
+	p.Set(nil)
+	assert.Nil(t, p.List())
+}
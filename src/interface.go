@@ -2,8 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -14,13 +20,16 @@ const (
 	BOND   = "bond"
 	VLAN   = "vlan"
 	BRIDGE = "bridge"
-)
 
-func init() {
-	log.SetFormatter(&log.JSONFormatter{})
-	log.SetOutput(os.Stdout)
-	log.SetLevel(log.InfoLevel)
-}
+	MACVLAN = "macvlan"
+	IPVLAN  = "ipvlan"
+	VXLAN   = "vxlan"
+	TUNNEL  = "tunnel" // gre, gretap, ipip, sit or ip6tnl
+	VETH    = "veth"
+
+	UP   = "up"
+	DOWN = "down"
+)
 
 type Config struct {
 	HostId  string
@@ -28,6 +37,12 @@ type Config struct {
 	Bonds   []Bond
 	Bridges []Bridge
 	Vlans   []Vlan
+	// omitted when empty so the stored configs written before stay the same
+	Macvlans []Macvlan `json:",omitempty"`
+	Ipvlans  []Ipvlan  `json:",omitempty"`
+	Vxlans   []Vxlan   `json:",omitempty"`
+	Tunnels  []Tunnel  `json:",omitempty"`
+	Veths    []Veth    `json:",omitempty"`
 	//后期想到上面新的配置项可以加在这里
 }
 
@@ -35,31 +50,123 @@ type Device struct {
 	Index  int
 	Name   string
 	IpNets []string
+	// the link with this permanent MAC address or PCI address is renamed to Name before applying
+	MatchMac     string `json:",omitempty"`
+	MatchPci     string `json:",omitempty"`
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
 }
 
 type Bond struct {
-	Index  int
-	Name   string
-	Mode   int
-	Devs   []string
-	IpNets []string
+	Index        int
+	Name         string
+	Mode         int
+	Devs         []string
+	IpNets       []string
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
 }
 
 type Bridge struct {
-	Index  int
-	Name   string
-	Devs   []string
-	IpNets []string
-	Mtu    int
-	Stp    string
+	Index        int
+	Name         string
+	Devs         []string
+	IpNets       []string
+	Mtu          int
+	Stp          string
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
 }
 
 type Vlan struct {
+	Index        int
+	Name         string
+	Tag          int
+	Parent       string
+	IpNets       []string
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
+}
+
+type Macvlan struct {
+	Index        int
+	Name         string
+	Parent       string
+	Mode         string // bridge, private, vepa or passthru, bridge if empty
+	IpNets       []string
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
+}
+
+// an ipvlan always has the MAC address of its parent
+type Ipvlan struct {
 	Index  int
 	Name   string
-	Tag    int
 	Parent string
+	Mode   string // l2, l3 or l3s, l2 if empty
 	IpNets []string
+	Mtu    int    `json:",omitempty"`
+	Alias  string `json:",omitempty"`
+	State  string `json:",omitempty"` // up or down, up if empty
+}
+
+type Vxlan struct {
+	Index        int
+	Name         string
+	Vni          int
+	Local        string `json:",omitempty"` // source address
+	Remote       string `json:",omitempty"` // unicast remote, or
+	Group        string `json:",omitempty"` // multicast group, needs Dev
+	Port         int    `json:",omitempty"` // UDP port, 4789 if empty
+	Dev          string `json:",omitempty"` // underlay device
+	Learning     string `json:",omitempty"` // on or off, on if empty
+	IpNets       []string
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"`
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
+}
+
+// a point-to-point tunnel
+type Tunnel struct {
+	Index        int
+	Name         string
+	Mode         string // gre, gretap, ipip, sit or ip6tnl
+	Local        string `json:",omitempty"` // an address of the host
+	Remote       string
+	Key          int    `json:",omitempty"` // gre and gretap only
+	Ttl          int    `json:",omitempty"` // 0 inherits from the inner packet
+	Dev          string `json:",omitempty"` // underlay device
+	IpNets       []string
+	Mtu          int    `json:",omitempty"`
+	HardwareAddr string `json:",omitempty"` // gretap only
+	Alias        string `json:",omitempty"`
+	State        string `json:",omitempty"` // up or down, up if empty
+}
+
+// a veth pair, the peer can be moved into the namespace PeerNetns under /var/run/netns or the one of
+// process PeerPid. Mtu is of both ends, the other settings are of the end Name
+type Veth struct {
+	Index        int
+	Name         string
+	Peer         string
+	PeerNetns    string `json:",omitempty"`
+	PeerPid      int    `json:",omitempty"`
+	IpNets       []string
+	PeerIpNets   []string `json:",omitempty"`
+	Mtu          int      `json:",omitempty"`
+	HardwareAddr string   `json:",omitempty"`
+	Alias        string   `json:",omitempty"`
+	State        string   `json:",omitempty"` // up or down, up if empty
 }
 
 func PutToDataSource(config Config) error {
@@ -68,37 +175,117 @@ func PutToDataSource(config Config) error {
 		log.WithError(err).Error("Put to database failed cuz convert json failed")
 		return err
 	}
-	DataSource["network"] = string(data)
+	start := time.Now()
+	if err := putDataSource("network", string(data)); err != nil {
+		log.WithError(err).Error("Put to database failed cuz write file failed")
+		return err
+	}
+	dsWriteDuration.Observe(time.Since(start).Seconds())
+	events.Publish(Event{Type: CONFIG_CHANGED})
 	return nil
 }
 
-//not thread safe
-func Apply(config Config) error {
+// serialize everything that rebuilds the network: apply, init and reconcile
+var applyLock sync.Mutex
+
+//not thread safe, hold applyLock when calling
+func Apply(config Config) (err error) {
+	start := time.Now()
+	applyTotal.Inc()
+	applyState.start()
+	events.Publish(Event{Type: APPLY_STARTED})
+	defer func() {
+		applyDuration.Observe(time.Since(start).Seconds())
+		applyState.finish(err)
+		e := Event{Type: APPLY_FINISHED}
+		if err != nil {
+			e.Detail = err.Error()
+		}
+		events.Publish(e)
+	}()
+
+	config = resolveDevs(config)
+	// the parts on missing devices are applied when they show up
+	c := currentDaemonConfig()
+	missing := waitForDevices(config, c.duration(c.DeviceWait))
+	pending.Set(missing)
+	if len(missing) > 0 {
+		log.WithField("Devices", missing).Warn("Devices not found, apply the rest")
+		config = withoutDevices(config, missing)
+	}
+
 	if err := breakNetwork(); err != nil {
 		log.WithError(err).Error("Break network failed")
+		applyFailures.WithLabelValues("break").Inc()
+		return err
+	}
+
+	if err := renameDevices(config.Devices); err != nil {
+		log.WithError(err).Error("Rename device fail")
+		applyFailures.WithLabelValues("device").Inc()
 		return err
 	}
 
 	if err := setDevice(config.Devices); err != nil {
 		log.WithError(err).Error("Set device fail")
+		applyFailures.WithLabelValues("device").Inc()
 		return err
 	}
 
 	if err := buildBond(config.Bonds); err != nil {
 		log.WithError(err).Error("Build bond fail")
+		applyFailures.WithLabelValues("bond").Inc()
 		return err
 	}
 
 	if err := buildVlan(config.Vlans); err != nil {
 		log.WithError(err).Error("Build vlan fail")
+		applyFailures.WithLabelValues("vlan").Inc()
+		return err
+	}
+
+	// before bridges, they can be ports
+	if err := buildVxlan(config.Vxlans); err != nil {
+		log.WithError(err).Error("Build vxlan fail")
+		applyFailures.WithLabelValues("vxlan").Inc()
+		return err
+	}
+
+	if err := buildTunnel(config.Tunnels); err != nil {
+		log.WithError(err).Error("Build tunnel fail")
+		applyFailures.WithLabelValues("tunnel").Inc()
+		return err
+	}
+
+	if err := buildVeth(config.Veths); err != nil {
+		log.WithError(err).Error("Build veth fail")
+		applyFailures.WithLabelValues("veth").Inc()
 		return err
 	}
 
 	if err := buildBridge(config.Bridges); err != nil {
 		log.WithError(err).Error("Build bridge fail")
+		applyFailures.WithLabelValues("bridge").Inc()
+		return err
+	}
+
+	if err := buildMacvlan(config.Macvlans); err != nil {
+		log.WithError(err).Error("Build macvlan fail")
+		applyFailures.WithLabelValues("macvlan").Inc()
+		return err
+	}
+
+	if err := buildIpvlan(config.Ipvlans); err != nil {
+		log.WithError(err).Error("Build ipvlan fail")
+		applyFailures.WithLabelValues("ipvlan").Inc()
 		return err
 	}
 
+	if err := setLinkStates(config, nil); err != nil {
+		log.WithError(err).Error("Set link state fail")
+		applyFailures.WithLabelValues("state").Inc()
+		return err
+	}
 	return nil
 }
 
@@ -110,6 +297,11 @@ func setDevice(devices []Device) error {
 			continue
 		}
 
+		if err := setLinkSettings(device.Name, device.Mtu, device.HardwareAddr, device.Alias); err != nil {
+			log.WithError(err).Error("Device " + device.Name + " set mtu, mac or alias failed")
+			return err
+		}
+
 		// set device's IP
 		if ipNets := device.IpNets; len(ipNets) > 0 {
 			for _, ipNet := range ipNets {
@@ -129,6 +321,11 @@ func buildBond(bonds []Bond) error {
 			log.WithError(err).Error("add bond failed")
 			return err
 		}
+		// after the slaves are added, the bond sets their mtu
+		if err := setLinkSettings(bond.Name, bond.Mtu, bond.HardwareAddr, bond.Alias); err != nil {
+			log.WithError(err).Error("bond set mtu, mac or alias failed")
+			return err
+		}
 		// assign bond's Ip,eg assign Ip:192.168.3.3 ,mask:255.255.255.0 to bond0
 		if ipNets := bond.IpNets; len(ipNets) > 0 {
 			for _, ipNet := range ipNets {
@@ -148,16 +345,278 @@ func buildVlan(vlans []Vlan) error {
 			log.WithError(err).Error("add vlan failed")
 			return err
 		}
+		if err := setLinkSettings(vlan.Name, vlan.Mtu, vlan.HardwareAddr, vlan.Alias); err != nil {
+			log.WithError(err).Error("vlan set mtu, mac or alias failed")
+			return err
+		}
+		for _, ipNet := range vlan.IpNets {
+			if err := setIP(vlan.Name, ipNet); err != nil {
+				log.WithError(err).Error("vlan add Ip failed")
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 func buildBridge(bridges []Bridge) error {
 	for _, bridge := range bridges {
-		if err := addBridge(bridge.Name, bridge.Devs, 1600); err != nil {
+		if err := addBridge(bridge.Name, bridge.Devs, bridge.Mtu); err != nil {
 			log.WithError(err).Error("add bridge failed")
 			return err
 		}
+		// adding ports changes the mtu of bridge, set it again
+		if err := setLinkSettings(bridge.Name, bridge.Mtu, bridge.HardwareAddr, bridge.Alias); err != nil {
+			log.WithError(err).Error("bridge set mtu, mac or alias failed")
+			return err
+		}
+		if err := setStp(bridge.Name, bridge.Stp); err != nil {
+			log.WithError(err).Error("bridge set stp failed")
+			return err
+		}
+		for _, ipNet := range bridge.IpNets {
+			if err := setIP(bridge.Name, ipNet); err != nil {
+				log.WithError(err).Error("bridge add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// netlink does not set the spanning tree of a bridge, sysfs does
+func setStp(name string, stp string) error {
+	if stp == "" {
+		return nil
+	}
+	state := "0"
+	if stp == "on" {
+		state = "1"
+	}
+	return ioutil.WriteFile(filepath.Join(sysClassNet, name, "bridge", "stp_state"), []byte(state), 0644)
+}
+
+// only re-apply the drifted parts of config: del the unwanted bond/vlan/bridge, rebuild the
+// changed or missing ones along with the interfaces built on them, then fix the addresses
+func applyDrift(config Config, drifts []Drift) error {
+	rebuild := make(map[string]bool)
+	for _, drift := range drifts {
+		if drift.Kind != LINK || drift.Type == DEVICE || linkSettingFields[drift.Field] {
+			continue
+		}
+		switch drift.Action {
+		case ADDED:
+			if err := delLink(drift.Name); err != nil {
+				return err
+			}
+		case CHANGED:
+			if err := delLink(drift.Name); err != nil {
+				return err
+			}
+			rebuild[drift.Name] = true
+		case REMOVED:
+			rebuild[drift.Name] = true
+		}
+	}
+	for _, drift := range drifts {
+		if drift.Kind == LINK && drift.Type == DEVICE && drift.Action == REMOVED {
+			log.WithField("Device", drift.Name).Error("Device does not exist in system")
+			return errors.New("Device " + drift.Name + " does not exist")
+		}
+	}
+
+	// vlans on a rebuilt interface and bridges containing it are gone or lost the slave, rebuild them too
+	for _, v := range config.Vlans {
+		if rebuild[v.Parent] && !rebuild[v.Name] {
+			if err := delLink(v.Name); err != nil {
+				return err
+			}
+			rebuild[v.Name] = true
+		}
+	}
+	for _, x := range config.Vxlans {
+		if rebuild[x.Dev] && !rebuild[x.Name] {
+			if err := delLink(x.Name); err != nil {
+				return err
+			}
+			rebuild[x.Name] = true
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if rebuild[tun.Dev] && !rebuild[tun.Name] {
+			if err := delLink(tun.Name); err != nil {
+				return err
+			}
+			rebuild[tun.Name] = true
+		}
+	}
+	// a veth is rebuilt as a pair, both ends are gone
+	for _, v := range config.Veths {
+		if rebuild[v.Name] || peerInHost(v) && rebuild[v.Peer] {
+			if err := delLink(v.Name); err != nil {
+				return err
+			}
+			rebuild[v.Name] = true
+			if peerInHost(v) {
+				rebuild[v.Peer] = true
+			}
+		}
+	}
+	for _, br := range config.Bridges {
+		for _, dev := range br.Devs {
+			if rebuild[dev] && !rebuild[br.Name] {
+				if err := delLink(br.Name); err != nil {
+					return err
+				}
+				rebuild[br.Name] = true
+			}
+		}
+	}
+	// so are the macvlans and ipvlans on them
+	for _, m := range config.Macvlans {
+		if rebuild[m.Parent] && !rebuild[m.Name] {
+			if err := delLink(m.Name); err != nil {
+				return err
+			}
+			rebuild[m.Name] = true
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if rebuild[i.Parent] && !rebuild[i.Name] {
+			if err := delLink(i.Name); err != nil {
+				return err
+			}
+			rebuild[i.Name] = true
+		}
+	}
+
+	var bonds []Bond
+	var vlans []Vlan
+	var vxlans []Vxlan
+	var tunnels []Tunnel
+	var veths []Veth
+	var bridges []Bridge
+	var macvlans []Macvlan
+	var ipvlans []Ipvlan
+	for _, b := range config.Bonds {
+		if rebuild[b.Name] {
+			if err := downLinks(b.Devs); err != nil {
+				return err
+			}
+			bonds = append(bonds, b)
+		}
+	}
+	for _, v := range config.Vlans {
+		if rebuild[v.Name] {
+			vlans = append(vlans, v)
+		}
+	}
+	for _, x := range config.Vxlans {
+		if rebuild[x.Name] {
+			vxlans = append(vxlans, x)
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if rebuild[tun.Name] {
+			tunnels = append(tunnels, tun)
+		}
+	}
+	for _, v := range config.Veths {
+		if rebuild[v.Name] {
+			veths = append(veths, v)
+		}
+	}
+	for _, br := range config.Bridges {
+		if rebuild[br.Name] {
+			bridges = append(bridges, br)
+		}
+	}
+	for _, m := range config.Macvlans {
+		if rebuild[m.Name] {
+			macvlans = append(macvlans, m)
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if rebuild[i.Name] {
+			ipvlans = append(ipvlans, i)
+		}
+	}
+	if err := buildBond(bonds); err != nil {
+		log.WithError(err).Error("Rebuild bond fail")
+		return err
+	}
+	if err := buildVlan(vlans); err != nil {
+		log.WithError(err).Error("Rebuild vlan fail")
+		return err
+	}
+	if err := buildVxlan(vxlans); err != nil {
+		log.WithError(err).Error("Rebuild vxlan fail")
+		return err
+	}
+	if err := buildTunnel(tunnels); err != nil {
+		log.WithError(err).Error("Rebuild tunnel fail")
+		return err
+	}
+	if err := buildVeth(veths); err != nil {
+		log.WithError(err).Error("Rebuild veth fail")
+		return err
+	}
+	if err := buildBridge(bridges); err != nil {
+		log.WithError(err).Error("Rebuild bridge fail")
+		return err
+	}
+	if err := buildMacvlan(macvlans); err != nil {
+		log.WithError(err).Error("Rebuild macvlan fail")
+		return err
+	}
+	if err := buildIpvlan(ipvlans); err != nil {
+		log.WithError(err).Error("Rebuild ipvlan fail")
+		return err
+	}
+
+	// rebuilt interfaces already got their settings and IPs when building
+	states := linkStates(config)
+	for _, drift := range drifts {
+		if drift.Kind != LINK || !linkSettingFields[drift.Field] || drift.Field == "State" || rebuild[drift.Name] {
+			continue
+		}
+		s := states[drift.Name]
+		if err := setLinkSettings(drift.Name, s.Mtu, s.HardwareAddr, s.Alias); err != nil {
+			return err
+		}
+	}
+	// the rebuilt ones and the slaves downed for them are down
+	restate := make(map[string]bool)
+	for name := range rebuild {
+		restate[name] = true
+	}
+	for _, b := range bonds {
+		for _, dev := range b.Devs {
+			restate[dev] = true
+		}
+	}
+	for _, drift := range drifts {
+		if drift.Kind == LINK && drift.Field == "State" {
+			restate[drift.Name] = true
+		}
+	}
+	if err := setLinkStates(config, restate); err != nil {
+		return err
+	}
+	for _, drift := range drifts {
+		if drift.Kind != ADDRESS || rebuild[drift.Name] {
+			continue
+		}
+		switch drift.Action {
+		case REMOVED:
+			if err := setIP(drift.Name, drift.Desired.(string)); err != nil {
+				return err
+			}
+		case ADDED:
+			if err := delIP(drift.Name, drift.Live.(string)); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -191,28 +650,99 @@ func grantConfig(link netlink.Link, devMap map[int][]string, config *Config) err
 		ipNets = append(ipNets, addr.IPNet.String())
 	}
 
+	attrs := link.Attrs()
+	mac := attrs.HardwareAddr.String()
 	switch link.Type() {
 	case DEVICE:
 		if deviceLink, ok := link.(*netlink.Device); ok {
-			config.Devices = append(config.Devices, Device{deviceLink.Index, deviceLink.Name, ipNets})
+			// a bond slave has the mac of the bond, it is not the device's own setting
+			if isBondSlave(attrs) {
+				mac = ""
+			}
+			config.Devices = append(config.Devices, Device{Index: deviceLink.Index, Name: deviceLink.Name, IpNets: ipNets,
+				Mtu: attrs.MTU, HardwareAddr: mac, Alias: attrs.Alias, State: adminState(attrs)})
 		}
 	case BOND:
 		if bondLink, ok := link.(*netlink.Bond); ok {
-			config.Bonds = append(config.Bonds, Bond{bondLink.Index, bondLink.Name, int(bondLink.Mode), devMap[link.Attrs().Index], ipNets})
+			config.Bonds = append(config.Bonds, Bond{Index: bondLink.Index, Name: bondLink.Name, Mode: int(bondLink.Mode), Devs: devMap[attrs.Index], IpNets: ipNets,
+				Mtu: attrs.MTU, HardwareAddr: mac, Alias: attrs.Alias, State: adminState(attrs)})
 		}
 	case VLAN:
 		if vlanLink, ok := link.(*netlink.Vlan); ok {
-			parent, _ := netlink.LinkByIndex(link.Attrs().ParentIndex)
-			config.Vlans = append(config.Vlans, Vlan{vlanLink.Index, vlanLink.Name, vlanLink.VlanId, parent.Attrs().Name, ipNets})
+			config.Vlans = append(config.Vlans, Vlan{Index: vlanLink.Index, Name: vlanLink.Name, Tag: vlanLink.VlanId, Parent: parentName(attrs), IpNets: ipNets,
+				Mtu: attrs.MTU, HardwareAddr: mac, Alias: attrs.Alias, State: adminState(attrs)})
 		}
 	case BRIDGE:
 		if bridgeLink, ok := link.(*netlink.Bridge); ok {
-			config.Bridges = append(config.Bridges, Bridge{bridgeLink.Index, bridgeLink.Name, devMap[link.Attrs().Index], ipNets, bridgeLink.MTU, ""})
+			config.Bridges = append(config.Bridges, Bridge{Index: bridgeLink.Index, Name: bridgeLink.Name, Devs: devMap[attrs.Index], IpNets: ipNets,
+				Mtu: attrs.MTU, HardwareAddr: mac, Alias: attrs.Alias, State: adminState(attrs)})
+		}
+	case MACVLAN:
+		if macvlanLink, ok := link.(*netlink.Macvlan); ok {
+			config.Macvlans = append(config.Macvlans, Macvlan{Index: macvlanLink.Index, Name: macvlanLink.Name, Parent: parentName(attrs), Mode: macvlanModeName(macvlanLink.Mode), IpNets: ipNets,
+				Mtu: attrs.MTU, HardwareAddr: mac, Alias: attrs.Alias, State: adminState(attrs)})
+		}
+	case IPVLAN:
+		if ipvlanLink, ok := link.(*netlink.IPVlan); ok {
+			config.Ipvlans = append(config.Ipvlans, Ipvlan{Index: ipvlanLink.Index, Name: ipvlanLink.Name, Parent: parentName(attrs), Mode: ipvlanModeName(ipvlanLink.Mode), IpNets: ipNets,
+				Mtu: attrs.MTU, Alias: attrs.Alias, State: adminState(attrs)})
+		}
+	case VXLAN:
+		if vxlanLink, ok := link.(*netlink.Vxlan); ok {
+			x := vxlanOf(vxlanLink)
+			x.IpNets, x.Mtu, x.HardwareAddr, x.Alias, x.State = ipNets, attrs.MTU, mac, attrs.Alias, adminState(attrs)
+			config.Vxlans = append(config.Vxlans, x)
+		}
+	case VETH:
+		if vethLink, ok := link.(*netlink.Veth); ok {
+			v, ok, err := vethOf(vethLink)
+			if err != nil {
+				return err
+			}
+			if ok {
+				v.IpNets, v.Mtu, v.HardwareAddr, v.Alias, v.State = ipNets, attrs.MTU, mac, attrs.Alias, adminState(attrs)
+				config.Veths = append(config.Veths, v)
+			}
+		}
+	default:
+		if isTunnel(link) {
+			tun := tunnelOf(link)
+			// the hardware address of the others is the local address
+			if tun.Mode != GRETAP {
+				mac = ""
+			}
+			tun.IpNets, tun.Mtu, tun.HardwareAddr, tun.Alias, tun.State = ipNets, attrs.MTU, mac, attrs.Alias, adminState(attrs)
+			config.Tunnels = append(config.Tunnels, tun)
 		}
 	}
 	return nil
 }
 
+func adminState(attrs *netlink.LinkAttrs) string {
+	if attrs.Flags&net.FlagUp != 0 {
+		return UP
+	}
+	return DOWN
+}
+
+// empty when the parent is gone or in another namespace
+func parentName(attrs *netlink.LinkAttrs) string {
+	parent, err := netlink.LinkByIndex(attrs.ParentIndex)
+	if err != nil {
+		log.WithError(err).Warn("Get parent of " + attrs.Name + " failed")
+		return ""
+	}
+	return parent.Attrs().Name
+}
+
+func isBondSlave(attrs *netlink.LinkAttrs) bool {
+	if attrs.MasterIndex == 0 {
+		return false
+	}
+	master, err := netlink.LinkByIndex(attrs.MasterIndex)
+	return err == nil && master.Type() == BOND
+}
+
 // get the interface's dev,eg: 5:eth0 eth1,5 is the bond0's index
 func getSlaveList(links []netlink.Link) map[int][]string {
 	m := make(map[int][]string)
@@ -225,10 +755,10 @@ func getSlaveList(links []netlink.Link) map[int][]string {
 }
 
 func getHostId() string {
-	return "1"
+	return currentDaemonConfig().HostId
 }
 
-// del bond, vlan, bridge, if exists
+// del macvlan, ipvlan, bond, vlan, bridge, vxlan, tunnel, veth, if exists
 func delInterfaces() error {
 	links, err := netlink.LinkList()
 	if err != nil {
@@ -237,7 +767,19 @@ func delInterfaces() error {
 	}
 
 	for _, link := range links {
-		if link.Type() == BOND || link.Type() == VLAN || link.Type() == BRIDGE {
+		if link.Type() == MACVLAN || link.Type() == IPVLAN {
+			if err := netlink.LinkDel(link); err != nil {
+				log.WithError(err).Error(" Del " + link.Attrs().Name + " link failed")
+				return err
+			}
+		}
+	}
+	for _, link := range links {
+		if link.Type() == BOND || link.Type() == VLAN || link.Type() == BRIDGE || link.Type() == VXLAN || isTunnel(link) || link.Type() == VETH {
+			// gone with its peer
+			if _, err := netlink.LinkByIndex(link.Attrs().Index); err != nil && link.Type() == VETH {
+				continue
+			}
 			if err := netlink.LinkDel(link); err != nil {
 				log.WithError(err).Error(" Del " + link.Attrs().Name + " link failed")
 				return err
@@ -247,6 +789,38 @@ func delInterfaces() error {
 	return nil
 }
 
+// deleting a veth deletes its peer too, a missing link is deleted already
+func delLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Get link " + name + " failed")
+		return err
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		log.WithError(err).Error("Del " + name + " link failed")
+		return err
+	}
+	return nil
+}
+
+func downLinks(names []string) error {
+	for _, name := range names {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			log.WithError(err).Error("Get link " + name + " failed")
+			return err
+		}
+		if err := netlink.LinkSetDown(link); err != nil {
+			log.WithError(err).Error("Down " + name + " link failed")
+			return err
+		}
+	}
+	return nil
+}
+
 // down devices like eth0,eth1 etc.
 func downDevice() error {
 	links, err := netlink.LinkList()
@@ -283,7 +857,7 @@ func upAllLinks() error {
 }
 
 func getAdminInterface() string {
-	return "eth3"
+	return currentDaemonConfig().AdminInterface
 }
 
 func addBond(masterName string, mode int, dev []string) error {
@@ -328,6 +902,131 @@ func addVlan(name string, parent string, id int) error {
 	return nil
 }
 
+// bring the links in config up or down, lower ones first: devices, bonds, vlans, vxlans, tunnels, veths, bridges and then
+// macvlans and ipvlans.
+// Only the named links if names is not nil. The admin interface and lo are left as they are
+func setLinkStates(config Config, names map[string]bool) error {
+	set := func(name string, state string) error {
+		if names != nil && !names[name] {
+			return nil
+		}
+		return setLinkState(name, state)
+	}
+	for _, d := range config.Devices {
+		if err := set(d.Name, d.State); err != nil {
+			return err
+		}
+	}
+	for _, b := range config.Bonds {
+		if err := set(b.Name, b.State); err != nil {
+			return err
+		}
+	}
+	for _, v := range config.Vlans {
+		if err := set(v.Name, v.State); err != nil {
+			return err
+		}
+	}
+	for _, x := range config.Vxlans {
+		if err := set(x.Name, x.State); err != nil {
+			return err
+		}
+	}
+	for _, tun := range config.Tunnels {
+		if err := set(tun.Name, tun.State); err != nil {
+			return err
+		}
+	}
+	for _, v := range config.Veths {
+		if err := set(v.Name, v.State); err != nil {
+			return err
+		}
+		if !peerInHost(v) {
+			continue
+		}
+		if err := set(v.Peer, v.State); err != nil {
+			return err
+		}
+	}
+	for _, br := range config.Bridges {
+		if err := set(br.Name, br.State); err != nil {
+			return err
+		}
+	}
+	for _, m := range config.Macvlans {
+		if err := set(m.Name, m.State); err != nil {
+			return err
+		}
+	}
+	for _, i := range config.Ipvlans {
+		if err := set(i.Name, i.State); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setLinkState(name string, state string) error {
+	if name == getAdminInterface() || name == "lo" {
+		return nil
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		log.WithError(err).Error("Get link " + name + " failed")
+		return err
+	}
+	if state == DOWN {
+		err = netlink.LinkSetDown(link)
+	} else {
+		err = netlink.LinkSetUp(link)
+	}
+	if err != nil {
+		log.WithError(err).Error("Set " + name + " link " + orUp(state) + " failed")
+		return err
+	}
+	return nil
+}
+
+func orUp(state string) string {
+	if state == "" {
+		return UP
+	}
+	return state
+}
+
+// set what is given, 0 or empty means keep the current one
+func setLinkSettings(name string, mtu int, mac string, alias string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		log.WithError(err).Error("Get link " + name + " failed")
+		return err
+	}
+	if mtu != 0 && mtu != link.Attrs().MTU {
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			log.WithError(err).Error("link " + name + " set mtu " + strconv.Itoa(mtu) + " failed.")
+			return err
+		}
+	}
+	if mac != "" && !sameMac(mac, link.Attrs().HardwareAddr.String()) {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			log.WithError(err).Error("parse mac " + mac + " failed")
+			return err
+		}
+		if err := netlink.LinkSetHardwareAddr(link, hw); err != nil {
+			log.WithError(err).Error("link " + name + " set mac " + mac + " failed.")
+			return err
+		}
+	}
+	if alias != "" && alias != link.Attrs().Alias {
+		if err := netlink.LinkSetAlias(link, alias); err != nil {
+			log.WithError(err).Error("link " + name + " set alias failed.")
+			return err
+		}
+	}
+	return nil
+}
+
 func getIndexByName(name string) (int, error) {
 	link, err := netlink.LinkByName(name)
 	if err != nil {
@@ -341,7 +1040,7 @@ func addSlave(masterName string, dev []string) error {
 	for _, devName := range dev {
 		slave, err := netlink.LinkByName(devName)
 		if err != nil {
-			log.WithError(err).Error("Get slave link " + slave.Attrs().Name + " failed")
+			log.WithError(err).Error("Get slave link " + devName + " failed")
 			return err
 		}
 
@@ -380,6 +1079,26 @@ func setIP(name string, ipNet string) error {
 	return nil
 }
 
+func delIP(name string, ipNet string) error {
+	addr, err := netlink.ParseAddr(ipNet)
+	if err != nil {
+		log.WithError(err).Error("parse addr " + ipNet + " failed")
+		return err
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		log.WithError(err).Error("Get link " + name + " failed")
+		return err
+	}
+
+	if err := netlink.AddrDel(link, addr); err != nil {
+		log.WithError(err).Error("link " + name + " del Ip" + ipNet + " failed.")
+		return err
+	}
+	return nil
+}
+
 func setNoIP() error {
 	links, err := netlink.LinkList()
 	if err != nil {
@@ -423,4 +1142,19 @@ func printLinks(config Config) {
 	for _, vlan := range config.Vlans {
 		fmt.Println(vlan)
 	}
+	for _, macvlan := range config.Macvlans {
+		fmt.Println(macvlan)
+	}
+	for _, ipvlan := range config.Ipvlans {
+		fmt.Println(ipvlan)
+	}
+	for _, vxlan := range config.Vxlans {
+		fmt.Println(vxlan)
+	}
+	for _, tunnel := range config.Tunnels {
+		fmt.Println(tunnel)
+	}
+	for _, veth := range config.Veths {
+		fmt.Println(veth)
+	}
 }
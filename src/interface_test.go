@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,6 +45,27 @@ func TestAddBridge(t *testing.T) {
 	breakNetwork()
 }
 
+func TestSetStp(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sysclassnet")
+	defer os.RemoveAll(dir)
+	defer func(old string) { sysClassNet = old }(sysClassNet)
+	sysClassNet = dir
+	os.MkdirAll(filepath.Join(dir, "br1", "bridge"), 0755)
+	stpState := filepath.Join(dir, "br1", "bridge", "stp_state")
+
+	assert.Nil(t, setStp("br1", "on"))
+	state, _ := ioutil.ReadFile(stpState)
+	assert.Equal(t, "1", string(state))
+	assert.Nil(t, setStp("br1", "off"))
+	state, _ = ioutil.ReadFile(stpState)
+	assert.Equal(t, "0", string(state))
+	// an empty Stp keeps the current state
+	assert.Nil(t, setStp("br1", ""))
+	state, _ = ioutil.ReadFile(stpState)
+	assert.Equal(t, "0", string(state))
+	assert.NotNil(t, setStp("br2", "on"))
+}
+
 func TestAddVlan(t *testing.T) {
 	breakNetwork()
 	addVlan("vlan0", "eth2", 300)
@@ -82,7 +106,7 @@ func TestApply(t *testing.T) {
 
 	assert.Equal(t, "br00", sysConfig.Bridges[0].Name)
 	assert.Equal(t, []string{"eth1", "bond00"}, sysConfig.Bridges[0].Devs)
-	assert.Equal(t, 1500, sysConfig.Bridges[0].Mtu)
+	assert.Equal(t, 1800, sysConfig.Bridges[0].Mtu)
 
 	assert.Equal(t, "eth2.300", sysConfig.Vlans[0].Name)
 	assert.Equal(t, "eth2", sysConfig.Vlans[0].Parent)
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const unixPrefix = "unix:"
+
+// Listen on every address, TCP addresses are limited to the admin interface's addresses when adminOnly,
+// unix domain sockets get the file mode
+func Listen(addrs []string, socketMode os.FileMode, adminOnly bool) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, unixPrefix) {
+			l, err := listenUnix(strings.TrimPrefix(addr, unixPrefix), socketMode)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, l)
+			continue
+		}
+
+		tcpAddrs := []string{addr}
+		if adminOnly {
+			var err error
+			if tcpAddrs, err = adminAddrs(addr); err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+		for _, a := range tcpAddrs {
+			l, err := net.Listen("tcp", a)
+			if err != nil {
+				log.WithError(err).Error("Listen on " + a + " failed")
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners, nil
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	// remove the socket left by last run
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.WithError(err).Error("Listen on " + path + " failed")
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		log.WithError(err).Error("Chmod " + path + " failed")
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// replace the host of addr with every address on admin interface, so that the API is never
+// reachable from data plane networks
+func adminAddrs(addr string) ([]string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	link, err := netlink.LinkByName(getAdminInterface())
+	if err != nil {
+		log.WithError(err).Error("Get admin interface " + getAdminInterface() + " failed")
+		return nil, err
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostPorts []string
+	for _, a := range addrs {
+		// link-local addresses need a zone, skip them
+		if isLinkLocal(a.IP) {
+			continue
+		}
+		hostPorts = append(hostPorts, net.JoinHostPort(a.IP.String(), port))
+	}
+	if len(hostPorts) == 0 {
+		return nil, errors.New("Admin interface " + getAdminInterface() + " has no address to listen on")
+	}
+	return hostPorts, nil
+}
+
+// CertReloader serves the certificate read from files, Reload re-reads them eg on SIGHUP
+type CertReloader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// keep serving the old certificate if the new one is broken
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		log.WithError(err).Error("Load certificate " + r.certFile + " failed")
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// client certificates are verified against clientCA if given, and are optional so that
+// token and basic auth still work over TLS
+func NewTLSConfig(reloader *CertReloader, clientCA string) (*tls.Config, error) {
+	config := &tls.Config{GetCertificate: reloader.GetCertificate, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			log.WithError(err).Error("Read client CA " + clientCA + " failed")
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificate found in " + clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// Serve on every listener, TCP listeners are wrapped with TLS if tlsConfig is given,
+// returns when any of them fails, or http.ErrServerClosed after server.Shutdown
+func Serve(server *http.Server, listeners []net.Listener, tlsConfig *tls.Config) error {
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		if tlsConfig != nil && l.Addr().Network() == "tcp" {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		log.WithField("Addr", l.Addr().String()).Info("监听")
+		go func(l net.Listener) {
+			errCh <- server.Serve(l)
+		}(l)
+	}
+	return <-errCh
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "listen")
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "netcfg.sock")
+
+	listeners, err := Listen([]string{"127.0.0.1:0", "unix:" + sock}, 0600, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listeners))
+	assert.Equal(t, "tcp", listeners[0].Addr().Network())
+	fi, err := os.Stat(sock)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	_, err = Listen([]string{"unix:" + sock, "256.0.0.1:9090"}, 0600, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/vishvananda/netlink"
+)
+
+// the first one is the default
+var macvlanModes = []struct {
+	Name string
+	Mode netlink.MacvlanMode
+}{
+	{"bridge", netlink.MACVLAN_MODE_BRIDGE},
+	{"private", netlink.MACVLAN_MODE_PRIVATE},
+	{"vepa", netlink.MACVLAN_MODE_VEPA},
+	{"passthru", netlink.MACVLAN_MODE_PASSTHRU},
+}
+
+var ipvlanModes = []struct {
+	Name string
+	Mode netlink.IPVlanMode
+}{
+	{"l2", netlink.IPVLAN_MODE_L2},
+	{"l3", netlink.IPVLAN_MODE_L3},
+	{"l3s", netlink.IPVLAN_MODE_L3S},
+}
+
+func macvlanMode(name string) (netlink.MacvlanMode, bool) {
+	for _, m := range macvlanModes {
+		if m.Name == name || name == "" {
+			return m.Mode, true
+		}
+	}
+	return 0, false
+}
+
+func macvlanModeName(mode netlink.MacvlanMode) string {
+	for _, m := range macvlanModes {
+		if m.Mode == mode {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+func ipvlanMode(name string) (netlink.IPVlanMode, bool) {
+	for _, m := range ipvlanModes {
+		if m.Name == name || name == "" {
+			return m.Mode, true
+		}
+	}
+	return 0, false
+}
+
+func ipvlanModeName(mode netlink.IPVlanMode) string {
+	for _, m := range ipvlanModes {
+		if m.Mode == mode {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+func macvlanList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Macvlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Macvlans, Status: true, Message: "获取Macvlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func macvlanGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Macvlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if m, ok := findMacvlan(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Macvlan失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: m, Status: true, Message: "获取Macvlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func macvlanAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	m, err := getMacvlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Macvlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := MacvlanAdd(m); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Macvlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Macvlan", macvlanSettings(m)).Info("添加Macvlan")
+		configMutations.WithLabelValues(MACVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Macvlan添加成功", Code: http.StatusCreated}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func macvlanUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	m, err := getMacvlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Macvlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := MacvlanUpdate(m); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Macvlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Macvlan", macvlanSettings(m)).Info("更新Macvlan")
+		configMutations.WithLabelValues(MACVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Macvlan更新成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func macvlanDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := MacvlanDel(name); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Macvlan删除失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.Info("删除Macvlan:" + name)
+		configMutations.WithLabelValues(MACVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Macvlan删除成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipvlanList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Ipvlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Ipvlans, Status: true, Message: "获取Ipvlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipvlanGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Ipvlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if i, ok := findIpvlan(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Ipvlan失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: i, Status: true, Message: "获取Ipvlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipvlanAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	i, err := getIpvlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Ipvlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := IpvlanAdd(i); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Ipvlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Ipvlan", ipvlanSettings(i)).Info("添加Ipvlan")
+		configMutations.WithLabelValues(IPVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Ipvlan添加成功", Code: http.StatusCreated}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipvlanUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	i, err := getIpvlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Ipvlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := IpvlanUpdate(i); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Ipvlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Ipvlan", ipvlanSettings(i)).Info("更新Ipvlan")
+		configMutations.WithLabelValues(IPVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Ipvlan更新成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func ipvlanDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := IpvlanDel(name); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Ipvlan删除失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.Info("删除Ipvlan:" + name)
+		configMutations.WithLabelValues(IPVLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Ipvlan删除成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func MacvlanAdd(m Macvlan) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertMacvlan(macvlanSettings(m), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new macvlan is validated against the config without the old one, nothing is stored if it is invalid
+func MacvlanUpdate(m Macvlan) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findMacvlan(m.Name, userConfig)
+	m = macvlanSettings(m)
+	m.IpNets = old.IpNets
+	removeMacvlan(m.Name, &userConfig)
+	if err := insertMacvlan(m, &userConfig); err != nil {
+		log.WithError(err).Error("Macvlan " + m.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func MacvlanDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	removeMacvlan(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// validate and add the macvlan to userConfig, shared by add and update
+func insertMacvlan(m Macvlan, userConfig *Config) error {
+	if isLinkAlreadyExists(m.Name, *userConfig) {
+		log.WithError(ErrNameUsed).Error("Name:" + m.Name)
+		return ErrNameUsed
+	}
+	if !isLinkAlreadyExists(m.Parent, *userConfig) {
+		log.WithError(ErrDevsNull).Error("Parent:" + m.Parent)
+		return ErrDevsNull
+	}
+	if _, ok := macvlanMode(m.Mode); !ok {
+		return ErrMacvlanMode
+	}
+	if err := validateIpNets(m.Name, m.IpNets); err != nil {
+		return err
+	}
+
+	userConfig.Macvlans = append(userConfig.Macvlans, m)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeMacvlan(name string, userConfig *Config) {
+	for i, m := range userConfig.Macvlans {
+		if m.Name == name {
+			userConfig.Macvlans = append(userConfig.Macvlans[:i], userConfig.Macvlans[i+1:]...)
+			break
+		}
+	}
+}
+
+func IpvlanAdd(i Ipvlan) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertIpvlan(ipvlanSettings(i), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new ipvlan is validated against the config without the old one, nothing is stored if it is invalid
+func IpvlanUpdate(i Ipvlan) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findIpvlan(i.Name, userConfig)
+	i = ipvlanSettings(i)
+	i.IpNets = old.IpNets
+	removeIpvlan(i.Name, &userConfig)
+	if err := insertIpvlan(i, &userConfig); err != nil {
+		log.WithError(err).Error("Ipvlan " + i.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func IpvlanDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	removeIpvlan(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// validate and add the ipvlan to userConfig, shared by add and update
+func insertIpvlan(i Ipvlan, userConfig *Config) error {
+	if isLinkAlreadyExists(i.Name, *userConfig) {
+		log.WithError(ErrNameUsed).Error("Name:" + i.Name)
+		return ErrNameUsed
+	}
+	if !isLinkAlreadyExists(i.Parent, *userConfig) {
+		log.WithError(ErrDevsNull).Error("Parent:" + i.Parent)
+		return ErrDevsNull
+	}
+	if _, ok := ipvlanMode(i.Mode); !ok {
+		return ErrIpvlanMode
+	}
+	if err := validateIpNets(i.Name, i.IpNets); err != nil {
+		return err
+	}
+
+	userConfig.Ipvlans = append(userConfig.Ipvlans, i)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeIpvlan(name string, userConfig *Config) {
+	for i, ipvlan := range userConfig.Ipvlans {
+		if ipvlan.Name == name {
+			userConfig.Ipvlans = append(userConfig.Ipvlans[:i], userConfig.Ipvlans[i+1:]...)
+			break
+		}
+	}
+}
+
+// containers get their addresses along with the sub-interface, so IpNets are kept too
+func macvlanSettings(m Macvlan) Macvlan {
+	return Macvlan{Name: m.Name, Parent: m.Parent, Mode: m.Mode, IpNets: m.IpNets, Mtu: m.Mtu, HardwareAddr: m.HardwareAddr, Alias: m.Alias, State: m.State}
+}
+
+func ipvlanSettings(i Ipvlan) Ipvlan {
+	return Ipvlan{Name: i.Name, Parent: i.Parent, Mode: i.Mode, IpNets: i.IpNets, Mtu: i.Mtu, Alias: i.Alias, State: i.State}
+}
+
+func findMacvlan(name string, config Config) (Macvlan, bool) {
+	for _, m := range config.Macvlans {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Macvlan{}, false
+}
+
+func findIpvlan(name string, config Config) (Ipvlan, bool) {
+	for _, i := range config.Ipvlans {
+		if i.Name == name {
+			return i, true
+		}
+	}
+	return Ipvlan{}, false
+}
+
+func getMacvlanJSONParam(req *http.Request) (Macvlan, error) {
+	req.ParseForm()
+	var m Macvlan
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &m); err != nil {
+		return Macvlan{}, errors.New("用户输入参数格式有误")
+	}
+	if m.Name == "" {
+		return Macvlan{}, errors.New("Macvlan's Name can not be empty")
+	}
+	if m.Parent == "" {
+		return Macvlan{}, errors.New("Macvlan's parent can not be empty")
+	}
+	return m, nil
+}
+
+func getIpvlanJSONParam(req *http.Request) (Ipvlan, error) {
+	req.ParseForm()
+	var i Ipvlan
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &i); err != nil {
+		return Ipvlan{}, errors.New("用户输入参数格式有误")
+	}
+	if i.Name == "" {
+		return Ipvlan{}, errors.New("Ipvlan's Name can not be empty")
+	}
+	if i.Parent == "" {
+		return Ipvlan{}, errors.New("Ipvlan's parent can not be empty")
+	}
+	return i, nil
+}
+
+func buildMacvlan(macvlans []Macvlan) error {
+	for _, m := range macvlans {
+		if err := addMacvlan(m.Name, m.Parent, m.Mode); err != nil {
+			log.WithError(err).Error("add macvlan failed")
+			return err
+		}
+		if err := setLinkSettings(m.Name, m.Mtu, m.HardwareAddr, m.Alias); err != nil {
+			log.WithError(err).Error("macvlan set mtu, mac or alias failed")
+			return err
+		}
+		for _, ipNet := range m.IpNets {
+			if err := setIP(m.Name, ipNet); err != nil {
+				log.WithError(err).Error("macvlan add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func buildIpvlan(ipvlans []Ipvlan) error {
+	for _, i := range ipvlans {
+		if err := addIpvlan(i.Name, i.Parent, i.Mode); err != nil {
+			log.WithError(err).Error("add ipvlan failed")
+			return err
+		}
+		if err := setLinkSettings(i.Name, i.Mtu, "", i.Alias); err != nil {
+			log.WithError(err).Error("ipvlan set mtu or alias failed")
+			return err
+		}
+		for _, ipNet := range i.IpNets {
+			if err := setIP(i.Name, ipNet); err != nil {
+				log.WithError(err).Error("ipvlan add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addMacvlan(name string, parent string, modeName string) error {
+	parentIndex, err := getIndexByName(parent)
+	if err != nil {
+		log.WithError(err).Error("get parent device " + parent + "'s index fail ")
+		return err
+	}
+	mode, ok := macvlanMode(modeName)
+	if !ok {
+		return ErrMacvlanMode
+	}
+
+	macvlan := &netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parentIndex}, Mode: mode}
+	if err := netlink.LinkAdd(macvlan); err != nil {
+		log.WithError(err).Error("Add macvlan " + name + " fail ")
+		return err
+	}
+	return nil
+}
+
+func addIpvlan(name string, parent string, modeName string) error {
+	parentIndex, err := getIndexByName(parent)
+	if err != nil {
+		log.WithError(err).Error("get parent device " + parent + "'s index fail ")
+		return err
+	}
+	mode, ok := ipvlanMode(modeName)
+	if !ok {
+		return ErrIpvlanMode
+	}
+
+	ipvlan := &netlink.IPVlan{LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parentIndex}, Mode: mode}
+	if err := netlink.LinkAdd(ipvlan); err != nil {
+		log.WithError(err).Error("Add ipvlan " + name + " fail ")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "netcfg"
+
+var (
+	applyTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apply_total",
+		Help:      "Number of applies of the config in database to system.",
+	})
+	applyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apply_duration_seconds",
+		Help:      "Time spent applying the config to system.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+	applyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apply_failures_total",
+		Help:      "Number of failed applies by the step that failed.",
+	}, []string{"step"})
+	configMutations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_mutations_total",
+		Help:      "Number of changes to the config in database by resource type.",
+	}, []string{"resource"})
+	dsWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "datasource_write_duration_seconds",
+		Help:      "Time spent writing the config to database.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+	driftCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_count",
+		Help:      "Number of differences between system and database found by the last check.",
+	})
+	pendingDevices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_devices",
+		Help:      "Number of devices in the config that were missing at the last apply.",
+	})
+)
+
+// per link metrics are read from netlink on every scrape
+var (
+	linkLabels     = []string{"name", "type"}
+	linkAdminUp    = prometheus.NewDesc(namespace+"_link_admin_up", "Whether the link is administratively up.", linkLabels, nil)
+	linkOperUp     = prometheus.NewDesc(namespace+"_link_oper_up", "Whether the link's operational state is up.", linkLabels, nil)
+	linkMtu        = prometheus.NewDesc(namespace+"_link_mtu_bytes", "MTU of the link.", linkLabels, nil)
+	linkRxBytes    = prometheus.NewDesc(namespace+"_link_receive_bytes_total", "Bytes received.", linkLabels, nil)
+	linkTxBytes    = prometheus.NewDesc(namespace+"_link_transmit_bytes_total", "Bytes transmitted.", linkLabels, nil)
+	linkRxPackets  = prometheus.NewDesc(namespace+"_link_receive_packets_total", "Packets received.", linkLabels, nil)
+	linkTxPackets  = prometheus.NewDesc(namespace+"_link_transmit_packets_total", "Packets transmitted.", linkLabels, nil)
+	linkRxErrors   = prometheus.NewDesc(namespace+"_link_receive_errors_total", "Receive errors.", linkLabels, nil)
+	linkTxErrors   = prometheus.NewDesc(namespace+"_link_transmit_errors_total", "Transmit errors.", linkLabels, nil)
+	linkRxDropped  = prometheus.NewDesc(namespace+"_link_receive_dropped_total", "Received packets dropped.", linkLabels, nil)
+	linkTxDropped  = prometheus.NewDesc(namespace+"_link_transmit_dropped_total", "Transmitted packets dropped.", linkLabels, nil)
+	bondSlaveUp    = prometheus.NewDesc(namespace+"_bond_slave_mii_up", "Whether the bond slave's MII status is up.", []string{"name", "master"}, nil)
+	bondSlaveFails = prometheus.NewDesc(namespace+"_bond_slave_link_failures_total", "Link failures of the bond slave.", []string{"name", "master"}, nil)
+	scrapeError    = prometheus.NewDesc(namespace+"_link_scrape_error", "Whether reading links from netlink failed.", nil, nil)
+)
+
+func init() {
+	prometheus.MustRegister(applyTotal, applyDuration, applyFailures, configMutations, dsWriteDuration, driftCount, pendingDevices, linkCollector{})
+}
+
+type linkCollector struct{}
+
+func (linkCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{linkAdminUp, linkOperUp, linkMtu, linkRxBytes, linkTxBytes, linkRxPackets, linkTxPackets,
+		linkRxErrors, linkTxErrors, linkRxDropped, linkTxDropped, bondSlaveUp, bondSlaveFails, scrapeError} {
+		ch <- desc
+	}
+}
+
+func (linkCollector) Collect(ch chan<- prometheus.Metric) {
+	status, err := GetStatusFromSys()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(scrapeError, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeError, prometheus.GaugeValue, 0)
+	for _, s := range status {
+		collectLink(ch, s)
+	}
+}
+
+func collectLink(ch chan<- prometheus.Metric, s LinkStatus) {
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, s.Name, s.Type)
+	}
+	counter := func(desc *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), s.Name, s.Type)
+	}
+
+	gauge(linkAdminUp, boolToFloat(s.AdminUp))
+	gauge(linkOperUp, boolToFloat(s.Carrier))
+	gauge(linkMtu, float64(s.Mtu))
+	counter(linkRxBytes, s.Statistics.RxBytes)
+	counter(linkTxBytes, s.Statistics.TxBytes)
+	counter(linkRxPackets, s.Statistics.RxPackets)
+	counter(linkTxPackets, s.Statistics.TxPackets)
+	counter(linkRxErrors, s.Statistics.RxErrors)
+	counter(linkTxErrors, s.Statistics.TxErrors)
+	counter(linkRxDropped, s.Statistics.RxDropped)
+	counter(linkTxDropped, s.Statistics.TxDropped)
+
+	if slave := s.BondSlave; slave != nil {
+		ch <- prometheus.MustNewConstMetric(bondSlaveUp, prometheus.GaugeValue, boolToFloat(slave.MiiStatus == "UP"), s.Name, s.Master)
+		ch <- prometheus.MustNewConstMetric(bondSlaveFails, prometheus.CounterValue, float64(slave.LinkFailureCount), s.Name, s.Master)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
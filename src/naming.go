@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Bond.Devs and Bridge.Devs can reference a device by its match instead of its name, eg mac:52:54:00:12:34:56
+const (
+	MAC_REF = "mac:"
+	PCI_REF = "pci:"
+)
+
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// where the PCI address of a link is found, the target of its device symlink
+var sysClassNet = "/sys/class/net"
+
+func validateMatch(d Device) error {
+	if d.MatchMac != "" {
+		if _, err := net.ParseMAC(d.MatchMac); err != nil {
+			log.WithError(err).Error("MatchMac:" + d.MatchMac)
+			return ErrMatchMac
+		}
+	}
+	if d.MatchPci != "" && !pciAddress.MatchString(strings.ToLower(d.MatchPci)) {
+		log.WithError(ErrMatchPci).Error("MatchPci:" + d.MatchPci)
+		return ErrMatchPci
+	}
+	return nil
+}
+
+func sameMac(a string, b string) bool {
+	ma, err := net.ParseMAC(a)
+	if err != nil {
+		return false
+	}
+	mb, err := net.ParseMAC(b)
+	return err == nil && ma.String() == mb.String()
+}
+
+// resolveDevs replaces the mac: and pci: references in Bond.Devs and Bridge.Devs with the names of
+// the matching devices in config. Unmatched references are kept, validateConfig reports them
+func resolveDevs(config Config) Config {
+	c := config
+	c.Bonds, c.Bridges = nil, nil
+	for _, b := range config.Bonds {
+		b.Devs = resolveRefs(config.Devices, b.Devs)
+		c.Bonds = append(c.Bonds, b)
+	}
+	for _, br := range config.Bridges {
+		br.Devs = resolveRefs(config.Devices, br.Devs)
+		c.Bridges = append(c.Bridges, br)
+	}
+	return c
+}
+
+func resolveRefs(devices []Device, devs []string) []string {
+	if devs == nil {
+		return nil
+	}
+	resolved := make([]string, len(devs))
+	for i, dev := range devs {
+		resolved[i] = dev
+		for _, d := range devices {
+			if strings.HasPrefix(dev, MAC_REF) && d.MatchMac != "" && sameMac(d.MatchMac, dev[len(MAC_REF):]) ||
+				strings.HasPrefix(dev, PCI_REF) && d.MatchPci != "" && strings.EqualFold(d.MatchPci, dev[len(PCI_REF):]) {
+				resolved[i] = d.Name
+				break
+			}
+		}
+	}
+	return resolved
+}
+
+// the PCI address of the link, empty for virtual ones
+func pciPath(name string) string {
+	target, err := os.Readlink(filepath.Join(sysClassNet, name, "device"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+func matches(d Device, link netlink.Link) bool {
+	if link.Type() != DEVICE {
+		return false
+	}
+	attrs := link.Attrs()
+	if d.MatchMac != "" {
+		// a bond slave takes the bond's MAC, the permanent one stays
+		mac := attrs.PermHWAddr
+		if len(mac) == 0 {
+			mac = attrs.HardwareAddr
+		}
+		if !sameMac(d.MatchMac, mac.String()) {
+			return false
+		}
+	}
+	if d.MatchPci != "" && !strings.EqualFold(d.MatchPci, pciPath(attrs.Name)) {
+		return false
+	}
+	return true
+}
+
+// the link of device d in the system, found by its match if any, otherwise by its name
+func findDeviceLink(d Device) (netlink.Link, error) {
+	if d.MatchMac == "" && d.MatchPci == "" {
+		return netlink.LinkByName(d.Name)
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if matches(d, link) {
+			return link, nil
+		}
+	}
+	return nil, errors.New("No link matches device " + d.Name)
+}
+
+// renameDevices gives the links matched by the devices their configured names. A link already
+// holding the name is moved aside to renameN first, so that names can be swapped
+func renameDevices(devices []Device) error {
+	for _, d := range devices {
+		if d.MatchMac == "" && d.MatchPci == "" || d.Name == getAdminInterface() {
+			continue
+		}
+		link, err := findDeviceLink(d)
+		if err != nil {
+			log.WithError(err).Error("Find device " + d.Name + " failed")
+			return err
+		}
+		if link.Attrs().Name == d.Name {
+			continue
+		}
+		if link.Attrs().Name == getAdminInterface() {
+			return errors.New("Device " + d.Name + " matches the admin interface " + getAdminInterface())
+		}
+		if other, err := netlink.LinkByName(d.Name); err == nil {
+			if err := renameLink(other, fmt.Sprintf("rename%d", other.Attrs().Index)); err != nil {
+				return err
+			}
+		}
+		if err := renameLink(link, d.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a link can only be renamed when it is down, bring it back up afterwards
+func renameLink(link netlink.Link, name string) error {
+	old := link.Attrs().Name
+	up := link.Attrs().Flags&net.FlagUp != 0
+	if up {
+		if err := netlink.LinkSetDown(link); err != nil {
+			log.WithError(err).Error("Down " + old + " link failed")
+			return err
+		}
+	}
+	if err := netlink.LinkSetName(link, name); err != nil {
+		log.WithError(err).Error("Rename link " + old + " to " + name + " failed")
+		return err
+	}
+	log.WithFields(log.Fields{"From": old, "To": name}).Info("重命名网卡")
+	if up {
+		if err := netlink.LinkSetUp(link); err != nil {
+			log.WithError(err).Error("Up " + name + " link failed")
+			return err
+		}
+	}
+	return nil
+}
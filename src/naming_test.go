@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+var namingConfig = Config{
+	Devices: []Device{{Name: "eth0", MatchMac: "52:54:00:12:34:56"}, {Name: "eth1", MatchPci: "0000:03:00.1"}, {Name: "eth2"}},
+	Bonds:   []Bond{{Name: "bond0", Devs: []string{"mac:52:54:00:12:34:56", "pci:0000:03:00.1"}}},
+	Bridges: []Bridge{{Name: "br0", Devs: []string{"eth2"}}},
+}
+
+func TestResolveDevs(t *testing.T) {
+	c := resolveDevs(namingConfig)
+	assert.Equal(t, []string{"eth0", "eth1"}, c.Bonds[0].Devs)
+	assert.Equal(t, []string{"eth2"}, c.Bridges[0].Devs)
+	// the original is not changed
+	assert.Equal(t, "mac:52:54:00:12:34:56", namingConfig.Bonds[0].Devs[0])
+
+	config := namingConfig
+	config.Bonds = []Bond{{Name: "bond0", Devs: []string{"mac:52:54:00:AB:CD:EF", "MAC:52:54:00:12:34:56"}}}
+	assert.Equal(t, []string{"mac:52:54:00:AB:CD:EF", "MAC:52:54:00:12:34:56"}, resolveDevs(config).Bonds[0].Devs)
+	config.Bonds = []Bond{{Name: "bond0", Devs: []string{"mac:52-54-00-12-34-56"}}}
+	assert.Equal(t, []string{"eth0"}, resolveDevs(config).Bonds[0].Devs)
+}
+
+func TestValidateMatch(t *testing.T) {
+	assert.Nil(t, validateConfig(namingConfig))
+
+	config := namingConfig
+	config.Bonds = []Bond{{Name: "bond0", Devs: []string{"mac:52:54:00:ab:cd:ef"}}}
+	assert.Equal(t, ErrDevsNull, validateConfig(config))
+
+	config = namingConfig
+	config.Bonds = []Bond{{Name: "bond0", Devs: []string{"mac:52:54:00:12:34:56", "eth0"}}}
+	assert.Equal(t, ErrDevsUsed, validateConfig(config))
+
+	config = namingConfig
+	config.Devices = []Device{{Name: "eth0", MatchMac: "52:54:00:12:34"}}
+	assert.Equal(t, ErrMatchMac, validateConfig(config))
+
+	config.Devices = []Device{{Name: "eth0", MatchPci: "03:00.0"}}
+	assert.Equal(t, ErrMatchPci, validateConfig(config))
+
+	config.Devices = []Device{{Name: "eth0", MatchPci: "0000:03:00.0"}, {Name: "eth1", MatchPci: "0000:03:00.0"}}
+	assert.Equal(t, ErrMatchUsed, validateConfig(config))
+}
+
+func TestMatches(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sysclassnet")
+	defer os.RemoveAll(dir)
+	defer func(old string) { sysClassNet = old }(sysClassNet)
+	sysClassNet = dir
+	os.MkdirAll(filepath.Join(dir, "enp3s0f1"), 0755)
+	os.Symlink("../../../0000:03:00.1", filepath.Join(dir, "enp3s0f1", "device"))
+
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	bondMac, _ := net.ParseMAC("52:54:00:ab:cd:ef")
+	link := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "enp3s0f1", HardwareAddr: bondMac, PermHWAddr: mac}}
+
+	assert.True(t, matches(namingConfig.Devices[0], link))
+	assert.True(t, matches(namingConfig.Devices[1], link))
+	assert.False(t, matches(Device{Name: "eth0", MatchPci: "0000:03:00.0"}, link))
+	assert.False(t, matches(Device{Name: "eth0", MatchMac: "52:54:00:ab:cd:ef"}, link))
+	assert.False(t, matches(namingConfig.Devices[0], &netlink.Bond{LinkAttrs: link.LinkAttrs}))
+
+	// without the permanent address
+	link.PermHWAddr = nil
+	assert.True(t, matches(Device{Name: "eth0", MatchMac: "52:54:00:ab:cd:ef"}, link))
+}
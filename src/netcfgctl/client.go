@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const unixPrefix = "unix:"
+
+// the config files tried in order when -config is not given
+var defaultConfigFiles = []string{"~/.netcfgctl.yaml", "/etc/network_config/netcfgctl.yaml"}
+
+// CtlConfig is read from the config file, then overridden by NETCFGCTL_SERVER, NETCFGCTL_TOKEN and flags
+type CtlConfig struct {
+	Server string `yaml:"server"` // eg http://127.0.0.1:9090, https://10.0.0.1:9443 or unix:/run/netcfg.sock
+	Token  string `yaml:"token"`
+	CAFile string `yaml:"ca_file"` // CA to verify the server's certificate with, system CAs if empty
+}
+
+// LoadCtlConfig reads file, or the first default config file that exists if file is empty
+func LoadCtlConfig(file string) (CtlConfig, error) {
+	c := CtlConfig{Server: "http://127.0.0.1:9090"}
+	if file == "" {
+		for _, f := range defaultConfigFiles {
+			if strings.HasPrefix(f, "~/") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					continue
+				}
+				f = filepath.Join(home, f[2:])
+			}
+			if _, err := os.Stat(f); err == nil {
+				file = f
+				break
+			}
+		}
+	}
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return CtlConfig{}, err
+		}
+		if err := yaml.UnmarshalStrict(data, &c); err != nil {
+			return CtlConfig{}, errors.New("Parse " + file + " failed: " + err.Error())
+		}
+	}
+	if v := os.Getenv("NETCFGCTL_SERVER"); v != "" {
+		c.Server = v
+	}
+	if v := os.Getenv("NETCFGCTL_TOKEN"); v != "" {
+		c.Token = v
+	}
+	return c, nil
+}
+
+// the response of every API
+type ResponseMessage struct {
+	Result  json.RawMessage `json:"result,omitempty"`
+	Status  bool            `json:"status"`
+	Message string          `json:"message"`
+	Code    int             `json:"code"`
+}
+
+// APIError is returned when the server answers with status false
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func NewClient(c CtlConfig) (*Client, error) {
+	transport := &http.Transport{}
+	base := strings.TrimRight(c.Server, "/")
+	if strings.HasPrefix(c.Server, unixPrefix) {
+		path := strings.TrimPrefix(c.Server, unixPrefix)
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		base = "http://unix"
+	} else if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "http://" + base
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificate found in " + c.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	// apply may take a while
+	return &Client{base: base, token: c.Token, http: &http.Client{Transport: transport, Timeout: 10 * time.Minute}}, nil
+}
+
+// Do sends body as JSON if not nil, returns the response, which is an *APIError if its status is false
+func (c *Client) Do(method string, path string, body interface{}) (ResponseMessage, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return ResponseMessage{}, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return ResponseMessage{}, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return ResponseMessage{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ResponseMessage{}, err
+	}
+	var rm ResponseMessage
+	if err := json.Unmarshal(data, &rm); err != nil {
+		return ResponseMessage{}, errors.New(resp.Status + ": " + strings.TrimSpace(string(data)))
+	}
+	if !rm.Status {
+		return rm, &APIError{Code: rm.Code, Message: rm.Message}
+	}
+	return rm, nil
+}
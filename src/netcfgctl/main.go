@@ -0,0 +1,586 @@
+// netcfgctl is the command-line client of the network config API
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	exitOK     = 0
+	exitFailed = 1 // the request failed or the server could not be reached
+	exitUsage  = 2
+)
+
+// returned by commands given wrong arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+type command struct {
+	name  string // eg: bond add
+	args  string
+	usage string
+	run   func(ctl *ctl, args []string) error
+}
+
+type ctl struct {
+	client *Client
+	output string // table or json
+	out    io.Writer
+}
+
+// a flag of add and update, the JSON field is its camel case, eg: hardware-addr is HardwareAddr
+type fieldFlag struct {
+	name  string
+	typ   string // int, string or list
+	usage string
+}
+
+var linkFlags = map[string][]fieldFlag{
+	"bond": {
+		{"mode", "int", "bond mode 0~6"},
+		{"devs", "list", "comma separated slaves, eg: eth0,eth1"},
+		{"mtu", "int", "MTU, the slaves get it too"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+	"bridge": {
+		{"devs", "list", "comma separated ports, eg: eth0,bond0"},
+		{"mtu", "int", "MTU, 1500 if not given on add"},
+		{"stp", "string", "spanning tree, on or off"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+	"vlan": {
+		{"parent", "string", "parent interface, eg: eth0"},
+		{"tag", "int", "vlan tag 1~4094"},
+		{"mtu", "int", "MTU, not larger than the parent's"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+	"macvlan": {
+		{"parent", "string", "parent interface, eg: eth0"},
+		{"mode", "string", "bridge, private, vepa or passthru, bridge if not given"},
+		{"ip-nets", "list", "comma separated addresses, eg: 192.168.1.10/24"},
+		{"mtu", "int", "MTU, not larger than the parent's"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+	"ipvlan": {
+		{"parent", "string", "parent interface, eg: eth0"},
+		{"mode", "string", "l2, l3 or l3s, l2 if not given"},
+		{"ip-nets", "list", "comma separated addresses, eg: 192.168.1.10/24"},
+		{"mtu", "int", "MTU, not larger than the parent's"},
+		{"alias", "string", "description of the interface"},
+	},
+	"vxlan": {
+		{"vni", "int", "vxlan id 1~16777215"},
+		{"local", "string", "source address"},
+		{"remote", "string", "unicast remote address"},
+		{"group", "string", "multicast group, instead of remote, needs dev"},
+		{"port", "int", "UDP port, 4789 if not given"},
+		{"dev", "string", "underlay interface, eg: eth0"},
+		{"learning", "string", "on or off, on if not given"},
+		{"ip-nets", "list", "comma separated addresses, eg: 192.168.1.10/24"},
+		{"mtu", "int", "MTU"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+	"tunnel": {
+		{"mode", "string", "gre, gretap, ipip, sit or ip6tnl"},
+		{"local", "string", "local address, an address of the host"},
+		{"remote", "string", "remote address"},
+		{"key", "int", "gre key, gre and gretap only"},
+		{"ttl", "int", "TTL 0~255, 0 inherits"},
+		{"dev", "string", "underlay interface, eg: eth0"},
+		{"ip-nets", "list", "comma separated addresses, eg: 192.168.1.10/24"},
+		{"mtu", "int", "MTU"},
+		{"hardware-addr", "string", "MAC address, gretap only"},
+		{"alias", "string", "description of the interface"},
+	},
+	"veth": {
+		{"peer", "string", "name of the other end"},
+		{"peer-netns", "string", "move the peer into this namespace under /var/run/netns"},
+		{"peer-pid", "int", "move the peer into the namespace of this process"},
+		{"ip-nets", "list", "comma separated addresses, eg: 192.168.1.10/24"},
+		{"peer-ip-nets", "list", "comma separated addresses of the peer"},
+		{"mtu", "int", "MTU of both ends"},
+		{"hardware-addr", "string", "MAC address, eg: 52:54:00:12:34:56"},
+		{"alias", "string", "description of the interface"},
+	},
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"config show", "", "show the config in database", configShow},
+		{"device list", "[-source system]", "list devices", linkList("device")},
+		{"device show", "NAME [-source system]", "show a device", linkShow("device")},
+	}
+	for _, kind := range []string{"bond", "bridge", "vlan", "vxlan", "tunnel", "veth", "macvlan", "ipvlan"} {
+		commands = append(commands,
+			command{kind + " list", "[-source system]", "list " + kind + "s", linkList(kind)},
+			command{kind + " show", "NAME [-source system]", "show a " + kind, linkShow(kind)},
+			command{kind + " add", "NAME " + flagsUsage(kind), "add a " + kind + " to database", linkAdd(kind)},
+			command{kind + " update", "NAME " + flagsUsage(kind), "change the given fields of a " + kind + " in database", linkUpdate(kind)},
+			command{kind + " del", "NAME", "delete a " + kind + " from database", linkDel(kind)},
+		)
+	}
+	commands = append(commands,
+		command{"ip list", "[-source system]", "list addresses of every interface", ipList},
+		command{"ip add", "NAME ADDRESS...", "add addresses like 192.168.1.10/24 to an interface in database", ipAdd},
+		command{"ip del", "NAME ADDRESS...", "delete addresses of an interface from database", ipDel},
+		command{"link up", "NAME", "bring an interface up on the system and keep it up in database", linkState("up")},
+		command{"link down", "NAME", "bring an interface down on the system and keep it down in database", linkState("down")},
+		command{"plan", "", "show what apply would change on the system", plan},
+		command{"apply", "", "apply the config in database to the system", apply},
+		command{"init", "-yes", "delete every bond, bridge, vlan, vxlan, tunnel, veth, macvlan and ipvlan and the addresses from the system", initNetwork},
+	)
+}
+
+func flagsUsage(kind string) string {
+	var usage []string
+	for _, f := range linkFlags[kind] {
+		usage = append(usage, "[-"+f.name+" "+strings.ToUpper(f.typ)+"]")
+	}
+	return strings.Join(usage, " ")
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("netcfgctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "config file, default ~/.netcfgctl.yaml or /etc/network_config/netcfgctl.yaml")
+	server := fs.String("server", "", "server address, eg: http://127.0.0.1:9090 or unix:/run/netcfg.sock (env NETCFGCTL_SERVER)")
+	token := fs.String("token", "", "bearer token (env NETCFGCTL_TOKEN)")
+	output := fs.String("o", "table", "output format, table or json")
+	fs.Usage = func() { printUsage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintln(stderr, "-o should be table or json")
+		return exitUsage
+	}
+
+	cmd, rest := findCommand(fs.Args())
+	if cmd == nil {
+		printUsage(stderr, fs)
+		return exitUsage
+	}
+
+	c, err := LoadCtlConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailed
+	}
+	if *server != "" {
+		c.Server = *server
+	}
+	if *token != "" {
+		c.Token = *token
+	}
+	client, err := NewClient(c)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailed
+	}
+
+	err = cmd.run(&ctl{client: client, output: *output, out: stdout}, rest)
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintln(stderr, "Error:", err)
+		fmt.Fprintf(stderr, "Usage: netcfgctl %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// the command with the longest matching name
+func findCommand(args []string) (*command, []string) {
+	for words := 2; words >= 1; words-- {
+		if len(args) < words {
+			continue
+		}
+		name := strings.Join(args[:words], " ")
+		for i := range commands {
+			if commands[i].name == name {
+				return &commands[i], args[words:]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func printUsage(out io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(out, "Usage: netcfgctl [-config FILE] [-server ADDR] [-token TOKEN] [-o table|json] COMMAND [ARGS]")
+	fmt.Fprintln(out, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n      %s\n", c.name, c.args, c.usage)
+	}
+	fmt.Fprintln(out, "\nOptions:")
+	fs.PrintDefaults()
+}
+
+// parse the flags after the positional arguments, which are returned
+func parseArgs(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	fs.SetOutput(ioutil.Discard)
+	if len(args) < positional {
+		return nil, usageError("missing arguments")
+	}
+	for _, arg := range args[:positional] {
+		if strings.HasPrefix(arg, "-") {
+			return nil, usageError("arguments should be given before flags")
+		}
+	}
+	if err := fs.Parse(args[positional:]); err != nil {
+		return nil, usageError(err.Error())
+	}
+	return args[:positional], nil
+}
+
+// print the whole response with -o json, otherwise call table
+func (c *ctl) print(rm ResponseMessage, table func() error) error {
+	if c.output == "json" {
+		data, _ := json.MarshalIndent(rm, "", "  ")
+		fmt.Fprintln(c.out, string(data))
+		return nil
+	}
+	return table()
+}
+
+func (c *ctl) mutate(method string, path string, body interface{}) error {
+	rm, err := c.client.Do(method, path, body)
+	if err != nil {
+		return err
+	}
+	return c.print(rm, func() error {
+		fmt.Fprintln(c.out, rm.Message)
+		return nil
+	})
+}
+
+func sourceQuery(source string) string {
+	if source == "" {
+		return ""
+	}
+	return "?source=" + url.QueryEscape(source)
+}
+
+func configShow(c *ctl, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("config show", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	rm, err := c.client.Do("GET", "/network/config", nil)
+	if err != nil {
+		return err
+	}
+	return c.print(rm, func() error {
+		var config Config
+		if err := json.Unmarshal(rm.Result, &config); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "HostId:", config.HostId)
+		printLinks(c.out, configRows(config))
+		return nil
+	})
+}
+
+// result is a JSON array of kind
+func rowsOf(kind string, result json.RawMessage) ([]linkRow, error) {
+	switch kind {
+	case "device":
+		var devices []Device
+		err := json.Unmarshal(result, &devices)
+		return deviceRows(devices), err
+	case "bond":
+		var bonds []Bond
+		err := json.Unmarshal(result, &bonds)
+		return bondRows(bonds), err
+	case "bridge":
+		var bridges []Bridge
+		err := json.Unmarshal(result, &bridges)
+		return bridgeRows(bridges), err
+	case "vlan":
+		var vlans []Vlan
+		err := json.Unmarshal(result, &vlans)
+		return vlanRows(vlans), err
+	case "vxlan":
+		var vxlans []Vxlan
+		err := json.Unmarshal(result, &vxlans)
+		return vxlanRows(vxlans), err
+	case "tunnel":
+		var tunnels []Tunnel
+		err := json.Unmarshal(result, &tunnels)
+		return tunnelRows(tunnels), err
+	case "veth":
+		var veths []Veth
+		err := json.Unmarshal(result, &veths)
+		return vethRows(veths), err
+	case "macvlan":
+		var macvlans []Macvlan
+		err := json.Unmarshal(result, &macvlans)
+		return macvlanRows(macvlans), err
+	case "ipvlan":
+		var ipvlans []Ipvlan
+		err := json.Unmarshal(result, &ipvlans)
+		return ipvlanRows(ipvlans), err
+	}
+	return nil, errors.New("Unknown type " + kind)
+}
+
+func linkList(kind string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		fs := flag.NewFlagSet(kind+" list", flag.ContinueOnError)
+		source := fs.String("source", "", "datasource or system")
+		if _, err := parseArgs(fs, args, 0); err != nil {
+			return err
+		}
+		rm, err := c.client.Do("GET", "/network/"+kind+sourceQuery(*source), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(rm, func() error {
+			rows, err := rowsOf(kind, rm.Result)
+			if err != nil {
+				return err
+			}
+			printLinks(c.out, rows)
+			return nil
+		})
+	}
+}
+
+func linkShow(kind string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		fs := flag.NewFlagSet(kind+" show", flag.ContinueOnError)
+		source := fs.String("source", "", "datasource or system")
+		pos, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		rm, err := c.client.Do("GET", "/network/"+kind+"/"+url.PathEscape(pos[0])+sourceQuery(*source), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(rm, func() error {
+			rows, err := rowsOf(kind, json.RawMessage("["+string(rm.Result)+"]"))
+			if err != nil {
+				return err
+			}
+			printLinks(c.out, rows)
+			return nil
+		})
+	}
+}
+
+// parse the flags of kind and put the ones given into fields, by their JSON names
+func parseFields(kind string, args []string, fields map[string]interface{}) (string, error) {
+	fs := flag.NewFlagSet(kind, flag.ContinueOnError)
+	values := make(map[string]*string)
+	for _, f := range linkFlags[kind] {
+		values[f.name] = fs.String(f.name, "", f.usage)
+	}
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range linkFlags[kind] {
+		given := false
+		fs.Visit(func(v *flag.Flag) { given = given || v.Name == f.name })
+		if !given {
+			continue
+		}
+		v := *values[f.name]
+		switch f.typ {
+		case "int":
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return "", usageError("-" + f.name + " should be a number")
+			}
+			fields[jsonField(f.name)] = i
+		case "list":
+			fields[jsonField(f.name)] = splitList(v)
+		default:
+			fields[jsonField(f.name)] = v
+		}
+	}
+	return pos[0], nil
+}
+
+func jsonField(flagName string) string {
+	var field string
+	for _, word := range strings.Split(flagName, "-") {
+		if word != "" {
+			field += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return field
+}
+
+func splitList(v string) []string {
+	list := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func linkAdd(kind string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		fields := make(map[string]interface{})
+		name, err := parseFields(kind, args, fields)
+		if err != nil {
+			return err
+		}
+		fields["Name"] = name
+		path := "/network/" + kind
+		// the API registers bond add with a trailing slash
+		if kind == "bond" {
+			path += "/"
+		}
+		return c.mutate("POST", path, fields)
+	}
+}
+
+// the fields not given are kept as in database
+func linkUpdate(kind string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		changes := make(map[string]interface{})
+		name, err := parseFields(kind, args, changes)
+		if err != nil {
+			return err
+		}
+		rm, err := c.client.Do("GET", "/network/"+kind+"/"+url.PathEscape(name), nil)
+		if err != nil {
+			return err
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(rm.Result, &fields); err != nil {
+			return err
+		}
+		for k, v := range changes {
+			fields[k] = v
+		}
+		return c.mutate("PUT", "/network/"+kind, fields)
+	}
+}
+
+func linkDel(kind string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		pos, err := parseArgs(flag.NewFlagSet(kind+" del", flag.ContinueOnError), args, 1)
+		if err != nil {
+			return err
+		}
+		return c.mutate("DELETE", "/network/"+kind+"/"+url.PathEscape(pos[0]), nil)
+	}
+}
+
+func linkState(state string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		pos, err := parseArgs(flag.NewFlagSet("link "+state, flag.ContinueOnError), args, 1)
+		if err != nil {
+			return err
+		}
+		return c.mutate("POST", "/network/link/"+url.PathEscape(pos[0])+"/"+state, nil)
+	}
+}
+
+func ipList(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("ip list", flag.ContinueOnError)
+	source := fs.String("source", "", "datasource or system")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	rm, err := c.client.Do("GET", "/network/Ip"+sourceQuery(*source), nil)
+	if err != nil {
+		return err
+	}
+	return c.print(rm, func() error {
+		var ips []IP
+		if err := json.Unmarshal(rm.Result, &ips); err != nil {
+			return err
+		}
+		printIPs(c.out, ips)
+		return nil
+	})
+}
+
+func ipAdd(c *ctl, args []string) error {
+	if len(args) < 2 {
+		return usageError("missing arguments")
+	}
+	return c.mutate("POST", "/network/Ip", IP{Name: args[0], Ip: args[1:]})
+}
+
+// the API deletes one address a time
+func ipDel(c *ctl, args []string) error {
+	if len(args) < 2 {
+		return usageError("missing arguments")
+	}
+	for _, addr := range args[1:] {
+		if err := c.mutate("DELETE", "/network/Ip", IP{Name: args[0], Ip: []string{addr}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func plan(c *ctl, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("plan", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	rm, err := c.client.Do("GET", "/network/drift", nil)
+	if err != nil {
+		return err
+	}
+	return c.print(rm, func() error {
+		var drifts []Drift
+		if err := json.Unmarshal(rm.Result, &drifts); err != nil {
+			return err
+		}
+		printDrifts(c.out, drifts)
+		return nil
+	})
+}
+
+func apply(c *ctl, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("apply", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	return c.mutate("GET", "/network/apply", nil)
+}
+
+// init breaks the network, so it asks for -yes
+func initNetwork(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if !*yes {
+		return usageError("init deletes every bond, bridge, vlan and address, add -yes to confirm")
+	}
+	return c.mutate("GET", "/network/init", nil)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	Method string
+	Path   string
+	Auth   string
+	Body   string
+}
+
+// a fake API server that records the requests
+func fakeServer(t *testing.T, requests *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		*requests = append(*requests, request{req.Method, req.URL.RequestURI(), req.Header.Get("Authorization"), string(body)})
+		rm := map[string]interface{}{"status": true, "message": "成功", "code": 200}
+		switch req.URL.Path {
+		case "/network/bond":
+			rm["result"] = []Bond{{Name: "bond0", Mode: 1, Devs: []string{"eth0", "eth1"}, IpNets: []string{"10.0.0.1/24"}}}
+		case "/network/bond/bond0":
+			rm["result"] = map[string]interface{}{"Index": 7, "Name": "bond0", "Mode": 1, "Devs": []string{"eth0", "eth1"}, "IpNets": []string{"10.0.0.1/24"}}
+		case "/network/drift":
+			rm["result"] = []Drift{{Kind: "link", Action: "removed", Type: "vlan", Name: "vlan100"}}
+		case "/network/vlan/vlan404":
+			rm = map[string]interface{}{"status": false, "message": "Vlan删除失败.Interface not found", "code": 500}
+		}
+		data, _ := json.Marshal(rm)
+		resp.Write(data)
+	}))
+}
+
+func runCtl(server string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"-config", "/dev/null", "-server", server, "-token", "secret"}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestBondCommands(t *testing.T) {
+	var requests []request
+	server := fakeServer(t, &requests)
+	defer server.Close()
+
+	code, out, _ := runCtl(server.URL, "bond", "list")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "TYPE")
+	assert.Regexp(t, `bond\s+bond0\s+eth0,eth1\s+mode=1\s+10.0.0.1/24`, out)
+	assert.Equal(t, request{"GET", "/network/bond", "Bearer secret", ""}, requests[0])
+
+	code, _, _ = runCtl(server.URL, "bond", "add", "bond1", "-mode", "4", "-devs", "eth2,eth3")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "POST", requests[1].Method)
+	assert.Equal(t, "/network/bond/", requests[1].Path)
+	assert.JSONEq(t, `{"Name":"bond1","Mode":4,"Devs":["eth2","eth3"]}`, requests[1].Body)
+
+	// only the given fields change, the others are kept as in database
+	code, _, _ = runCtl(server.URL, "bond", "update", "bond0", "-mode", "4")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "PUT", requests[3].Method)
+	assert.JSONEq(t, `{"Index":7,"Name":"bond0","Mode":4,"Devs":["eth0","eth1"],"IpNets":["10.0.0.1/24"]}`, requests[3].Body)
+
+	code, out, _ = runCtl(server.URL, "-o", "json", "bond", "del", "bond0")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, request{"DELETE", "/network/bond/bond0", "Bearer secret", ""}, requests[4])
+	assert.Contains(t, out, `"status": true`)
+}
+
+func TestOtherCommands(t *testing.T) {
+	var requests []request
+	server := fakeServer(t, &requests)
+	defer server.Close()
+
+	code, out, _ := runCtl(server.URL, "plan")
+	assert.Equal(t, exitOK, code)
+	assert.Regexp(t, `link\s+removed\s+vlan\s+vlan100`, out)
+
+	code, _, _ = runCtl(server.URL, "ip", "del", "eth0", "10.0.0.1/24", "10.0.0.2/24")
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"Name":"eth0","Ip":["10.0.0.2/24"]}`, requests[2].Body)
+
+	code, _, _ = runCtl(server.URL, "bond", "list", "-source", "system")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "/network/bond?source=system", requests[3].Path)
+
+	code, _, _ = runCtl(server.URL, "vlan", "add", "vlan5", "-parent", "bond0", "-tag", "5", "-mtu", "9000", "-hardware-addr", "52:54:00:12:34:56")
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"Name":"vlan5","Parent":"bond0","Tag":5,"Mtu":9000,"HardwareAddr":"52:54:00:12:34:56"}`, requests[4].Body)
+
+	code, _, _ = runCtl(server.URL, "link", "down", "eth1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, request{"POST", "/network/link/eth1/down", "Bearer secret", ""}, requests[5])
+
+	code, _, _ = runCtl(server.URL, "macvlan", "add", "mv0", "-parent", "eth0", "-mode", "vepa", "-ip-nets", "10.0.0.5/24")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "/network/macvlan", requests[6].Path)
+	assert.JSONEq(t, `{"Name":"mv0","Parent":"eth0","Mode":"vepa","IpNets":["10.0.0.5/24"]}`, requests[6].Body)
+
+	code, _, _ = runCtl(server.URL, "vxlan", "add", "vxlan100", "-vni", "100", "-remote", "10.0.0.2", "-dev", "eth0", "-learning", "off")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "/network/vxlan", requests[7].Path)
+	assert.JSONEq(t, `{"Name":"vxlan100","Vni":100,"Remote":"10.0.0.2","Dev":"eth0","Learning":"off"}`, requests[7].Body)
+
+	code, _, _ = runCtl(server.URL, "tunnel", "add", "gre1", "-mode", "gre", "-local", "10.0.0.1", "-remote", "10.0.1.1", "-key", "7", "-ttl", "64")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "/network/tunnel", requests[8].Path)
+	assert.JSONEq(t, `{"Name":"gre1","Mode":"gre","Local":"10.0.0.1","Remote":"10.0.1.1","Key":7,"Ttl":64}`, requests[8].Body)
+
+	code, _, _ = runCtl(server.URL, "veth", "add", "veth0", "-peer", "eth0", "-peer-netns", "web", "-peer-ip-nets", "10.0.0.5/24")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "/network/veth", requests[9].Path)
+	assert.JSONEq(t, `{"Name":"veth0","Peer":"eth0","PeerNetns":"web","PeerIpNets":["10.0.0.5/24"]}`, requests[9].Body)
+
+	code, _, _ = runCtl(server.URL, "bridge", "add", "br0", "-devs", "eth2", "-stp", "on")
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"Name":"br0","Devs":["eth2"],"Stp":"on"}`, requests[10].Body)
+
+	n := len(requests)
+	code, _, errOut := runCtl(server.URL, "init")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, errOut, "-yes")
+	assert.Equal(t, n, len(requests))
+}
+
+func TestExitCodes(t *testing.T) {
+	var requests []request
+	server := fakeServer(t, &requests)
+	defer server.Close()
+
+	code, _, errOut := runCtl(server.URL, "vlan", "del", "vlan404")
+	assert.Equal(t, exitFailed, code)
+	assert.Contains(t, errOut, "Interface not found")
+
+	code, _, _ = runCtl(server.URL, "bond", "add")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCtl(server.URL, "bond", "add", "bond1", "-mode", "x")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCtl(server.URL, "nonsense")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCtl("http://127.0.0.1:1", "apply")
+	assert.Equal(t, exitFailed, code)
+}
+
+func TestLoadCtlConfig(t *testing.T) {
+	f, _ := ioutil.TempFile("", "netcfgctl")
+	f.WriteString("server: unix:/run/netcfg.sock\ntoken: secret\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c, err := LoadCtlConfig(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, CtlConfig{Server: "unix:/run/netcfg.sock", Token: "secret"}, c)
+
+	os.Setenv("NETCFGCTL_TOKEN", "other")
+	defer os.Unsetenv("NETCFGCTL_TOKEN")
+	c, _ = LoadCtlConfig(f.Name())
+	assert.Equal(t, "other", c.Token)
+
+	client, err := NewClient(c)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(client.base, "http://"))
+
+	ioutil.WriteFile(f.Name(), []byte("sever: x\n"), 0600)
+	_, err = LoadCtlConfig(f.Name())
+	assert.Error(t, err)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// the parts of the daemon's types that are shown
+type Config struct {
+	HostId   string
+	Devices  []Device
+	Bonds    []Bond
+	Bridges  []Bridge
+	Vlans    []Vlan
+	Vxlans   []Vxlan
+	Tunnels  []Tunnel
+	Veths    []Veth
+	Macvlans []Macvlan
+	Ipvlans  []Ipvlan
+}
+
+type Device struct {
+	Name   string
+	IpNets []string
+	Mtu    int
+}
+
+type Bond struct {
+	Name   string
+	Mode   int
+	Devs   []string
+	IpNets []string
+	Mtu    int
+}
+
+type Bridge struct {
+	Name   string
+	Devs   []string
+	IpNets []string
+	Mtu    int
+	Stp    string
+}
+
+type Vlan struct {
+	Name   string
+	Tag    int
+	Parent string
+	IpNets []string
+	Mtu    int
+}
+
+type Vxlan struct {
+	Name   string
+	Vni    int
+	Remote string
+	Group  string
+	Port   int
+	Dev    string
+	IpNets []string
+	Mtu    int
+}
+
+type Tunnel struct {
+	Name   string
+	Mode   string
+	Local  string
+	Remote string
+	Dev    string
+	IpNets []string
+	Mtu    int
+}
+
+type Veth struct {
+	Name      string
+	Peer      string
+	PeerNetns string
+	PeerPid   int
+	IpNets    []string
+	Mtu       int
+}
+
+type Macvlan struct {
+	Name   string
+	Parent string
+	Mode   string
+	IpNets []string
+	Mtu    int
+}
+
+type Ipvlan struct {
+	Name   string
+	Parent string
+	Mode   string
+	IpNets []string
+	Mtu    int
+}
+
+type IP struct {
+	Name string
+	Ip   []string
+}
+
+type Drift struct {
+	Kind    string
+	Action  string
+	Type    string
+	Name    string
+	Field   string
+	Desired interface{}
+	Live    interface{}
+}
+
+// one row of the link table
+type linkRow struct {
+	Type    string
+	Name    string
+	Members string
+	Options string
+	IpNets  []string
+}
+
+func deviceRows(devices []Device) []linkRow {
+	var rows []linkRow
+	for _, d := range devices {
+		rows = append(rows, linkRow{Type: "device", Name: d.Name, Options: mtuOption(d.Mtu), IpNets: d.IpNets})
+	}
+	return rows
+}
+
+func bondRows(bonds []Bond) []linkRow {
+	var rows []linkRow
+	for _, b := range bonds {
+		rows = append(rows, linkRow{Type: "bond", Name: b.Name, Members: strings.Join(b.Devs, ","), Options: "mode=" + strconv.Itoa(b.Mode) + prefixed(mtuOption(b.Mtu)), IpNets: b.IpNets})
+	}
+	return rows
+}
+
+func bridgeRows(bridges []Bridge) []linkRow {
+	var rows []linkRow
+	for _, br := range bridges {
+		options := "mtu=" + strconv.Itoa(br.Mtu)
+		if br.Stp != "" {
+			options += " stp=" + br.Stp
+		}
+		rows = append(rows, linkRow{Type: "bridge", Name: br.Name, Members: strings.Join(br.Devs, ","), Options: options, IpNets: br.IpNets})
+	}
+	return rows
+}
+
+func vlanRows(vlans []Vlan) []linkRow {
+	var rows []linkRow
+	for _, v := range vlans {
+		rows = append(rows, linkRow{Type: "vlan", Name: v.Name, Members: v.Parent, Options: "tag=" + strconv.Itoa(v.Tag) + prefixed(mtuOption(v.Mtu)), IpNets: v.IpNets})
+	}
+	return rows
+}
+
+func vxlanRows(vxlans []Vxlan) []linkRow {
+	var rows []linkRow
+	for _, x := range vxlans {
+		options := "vni=" + strconv.Itoa(x.Vni)
+		if x.Remote != "" {
+			options += " remote=" + x.Remote
+		}
+		if x.Group != "" {
+			options += " group=" + x.Group
+		}
+		if x.Port != 0 {
+			options += " port=" + strconv.Itoa(x.Port)
+		}
+		rows = append(rows, linkRow{Type: "vxlan", Name: x.Name, Members: x.Dev, Options: options + prefixed(mtuOption(x.Mtu)), IpNets: x.IpNets})
+	}
+	return rows
+}
+
+func tunnelRows(tunnels []Tunnel) []linkRow {
+	var rows []linkRow
+	for _, tun := range tunnels {
+		options := "mode=" + tun.Mode + " remote=" + tun.Remote
+		if tun.Local != "" {
+			options += " local=" + tun.Local
+		}
+		rows = append(rows, linkRow{Type: "tunnel", Name: tun.Name, Members: tun.Dev, Options: options + prefixed(mtuOption(tun.Mtu)), IpNets: tun.IpNets})
+	}
+	return rows
+}
+
+func vethRows(veths []Veth) []linkRow {
+	var rows []linkRow
+	for _, v := range veths {
+		options := "peer=" + v.Peer
+		if v.PeerNetns != "" {
+			options += " netns=" + v.PeerNetns
+		}
+		if v.PeerPid != 0 {
+			options += " pid=" + strconv.Itoa(v.PeerPid)
+		}
+		rows = append(rows, linkRow{Type: "veth", Name: v.Name, Options: options + prefixed(mtuOption(v.Mtu)), IpNets: v.IpNets})
+	}
+	return rows
+}
+
+func macvlanRows(macvlans []Macvlan) []linkRow {
+	var rows []linkRow
+	for _, m := range macvlans {
+		rows = append(rows, linkRow{Type: "macvlan", Name: m.Name, Members: m.Parent, Options: "mode=" + orDefault(m.Mode, "bridge") + prefixed(mtuOption(m.Mtu)), IpNets: m.IpNets})
+	}
+	return rows
+}
+
+func ipvlanRows(ipvlans []Ipvlan) []linkRow {
+	var rows []linkRow
+	for _, i := range ipvlans {
+		rows = append(rows, linkRow{Type: "ipvlan", Name: i.Name, Members: i.Parent, Options: "mode=" + orDefault(i.Mode, "l2") + prefixed(mtuOption(i.Mtu)), IpNets: i.IpNets})
+	}
+	return rows
+}
+
+// the mtu when set, empty otherwise
+func mtuOption(mtu int) string {
+	if mtu == 0 {
+		return ""
+	}
+	return "mtu=" + strconv.Itoa(mtu)
+}
+
+func prefixed(option string) string {
+	if option == "" {
+		return ""
+	}
+	return " " + option
+}
+
+func configRows(c Config) []linkRow {
+	rows := deviceRows(c.Devices)
+	rows = append(rows, bondRows(c.Bonds)...)
+	rows = append(rows, bridgeRows(c.Bridges)...)
+	rows = append(rows, vlanRows(c.Vlans)...)
+	rows = append(rows, vxlanRows(c.Vxlans)...)
+	rows = append(rows, tunnelRows(c.Tunnels)...)
+	rows = append(rows, vethRows(c.Veths)...)
+	rows = append(rows, macvlanRows(c.Macvlans)...)
+	return append(rows, ipvlanRows(c.Ipvlans)...)
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printLinks(out io.Writer, rows []linkRow) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tMEMBERS\tOPTIONS\tADDRESSES")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Type, r.Name, orDash(r.Members), orDash(r.Options), orDash(strings.Join(r.IpNets, ",")))
+	}
+	w.Flush()
+}
+
+func printIPs(out io.Writer, ips []IP) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESSES")
+	for _, ip := range ips {
+		fmt.Fprintf(w, "%s\t%s\n", ip.Name, orDash(strings.Join(ip.Ip, ",")))
+	}
+	w.Flush()
+}
+
+func printDrifts(out io.Writer, drifts []Drift) {
+	if len(drifts) == 0 {
+		fmt.Fprintln(out, "No changes, the system matches the database.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tACTION\tTYPE\tNAME\tFIELD\tDESIRED\tLIVE")
+	for _, d := range drifts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Kind, d.Action, d.Type, d.Name, orDash(d.Field), value(d.Desired), value(d.Live))
+	}
+	w.Flush()
+}
+
+func value(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
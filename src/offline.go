@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// the commands run directly on this host without the HTTP server, eg for first boot and rescue
+var offlineCommands = map[string]func(o *offline, args []string) error{
+	"apply":    offlineApply,
+	"dump":     offlineDump,
+	"validate": offlineValidate,
+	"plan":     offlinePlan,
+}
+
+type offline struct {
+	out    io.Writer
+	file   string // -f
+	format string // -o
+}
+
+func isOfflineCommand(name string) bool {
+	_, ok := offlineCommands[name]
+	return ok
+}
+
+// RunOffline runs "netcfg COMMAND [-config FILE] [-f FILE] [-o FORMAT]", logs go to stderr so that
+// stdout only has the result. Returns the exit code: 0 ok, 1 failed, 2 wrong arguments
+func RunOffline(args []string, stdout io.Writer, stderr io.Writer) int {
+	name := args[0]
+	fs := flag.NewFlagSet("netcfg "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "daemon config file in YAML, for admin_interface, host_id and log settings")
+	o := &offline{out: stdout}
+	if name != "dump" {
+		fs.StringVar(&o.file, "f", "", "network config file in JSON or YAML, - for stdin")
+	}
+	if name == "dump" || name == "plan" {
+		formats := map[string]string{"dump": "json or yaml", "plan": "table or json"}
+		defaults := map[string]string{"dump": "json", "plan": "table"}
+		fs.StringVar(&o.format, "o", defaults[name], "output format, "+formats[name])
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if name != "dump" && o.file == "" {
+		fmt.Fprintln(stderr, "-f is required")
+		return 2
+	}
+
+	var configArgs []string
+	if *configFile != "" {
+		configArgs = []string{"-config", *configFile}
+	}
+	c, _, err := LoadDaemonConfig(configArgs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Load daemon config:", err)
+		return 1
+	}
+	applyDaemonConfig(c)
+	log.SetOutput(stderr)
+
+	if err := offlineCommands[name](o, fs.Args()); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// ReadConfigFile reads the network config in JSON, or YAML with the same field names
+func ReadConfigFile(file string) (Config, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if ext := filepath.Ext(file); ext == ".yaml" || ext == ".yml" || !json.Valid(data) {
+		// yaml decodes maps with interface{} keys, convert them so that the JSON field names apply
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return Config{}, errors.New("Parse " + file + " failed: " + err.Error())
+		}
+		if data, err = json.Marshal(jsonCompatible(v)); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, errors.New("Parse " + file + " failed: " + err.Error())
+	}
+	return config, nil
+}
+
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, value := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonCompatible(v[i])
+		}
+	}
+	return v
+}
+
+func (o *offline) readConfig() (Config, error) {
+	config, err := ReadConfigFile(o.file)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := validateConfig(config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+func offlineValidate(o *offline, _ []string) error {
+	if _, err := o.readConfig(); err != nil {
+		return err
+	}
+	fmt.Fprintln(o.out, o.file, "is valid")
+	return nil
+}
+
+// the config of this host, in the same format as apply -f takes
+func offlineDump(o *offline, _ []string) error {
+	config, err := GetConfigFromSys()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	switch o.format {
+	case "json":
+	case "yaml":
+		// keep the field names and order of JSON
+		var m yaml.MapSlice
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if data, err = yaml.Marshal(m); err != nil {
+			return err
+		}
+	default:
+		return errors.New("-o should be json or yaml")
+	}
+	fmt.Fprintln(o.out, string(data))
+	return nil
+}
+
+func offlinePlan(o *offline, _ []string) error {
+	if o.format != "table" && o.format != "json" {
+		return errors.New("-o should be table or json")
+	}
+	config, err := o.readConfig()
+	if err != nil {
+		return err
+	}
+	sysConfig, err := GetConfigFromSys()
+	if err != nil {
+		return err
+	}
+	drifts := Diff(resolveDevs(config), sysConfig)
+	if o.format == "json" {
+		data, _ := json.MarshalIndent(drifts, "", "    ")
+		fmt.Fprintln(o.out, string(data))
+		return nil
+	}
+	printDrifts(o.out, drifts)
+	return nil
+}
+
+// not guarded against a running daemon, stop it or use the API instead
+func offlineApply(o *offline, _ []string) error {
+	config, err := o.readConfig()
+	if err != nil {
+		return err
+	}
+	before, _ := GetConfigFromSys()
+	applyLock.Lock()
+	err = Apply(config)
+	applyLock.Unlock()
+	auditApply("offline", before, err)
+	auditLog.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(o.out, "应用网络配置成功")
+	return nil
+}
+
+func printDrifts(out io.Writer, drifts []Drift) {
+	if len(drifts) == 0 {
+		fmt.Fprintln(out, "No changes, the system matches the config.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tACTION\tTYPE\tNAME\tFIELD\tDESIRED\tLIVE")
+	for _, d := range drifts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Kind, d.Action, d.Type, d.Name, orDash(d.Field), driftValue(d.Desired), driftValue(d.Live))
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func driftValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
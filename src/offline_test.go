@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	want := Config{
+		Devices: []Device{{Name: "eth0"}, {Name: "eth1"}},
+		Bonds:   []Bond{{Name: "bond0", Mode: 1, Devs: []string{"eth0", "eth1"}, IpNets: []string{"10.0.0.1/24"}}},
+	}
+
+	jsonFile := writeTempFile(t, `{"Devices":[{"Name":"eth0"},{"Name":"eth1"}],"Bonds":[{"Name":"bond0","Mode":1,"Devs":["eth0","eth1"],"IpNets":["10.0.0.1/24"]}]}`)
+	defer os.Remove(jsonFile)
+	config, err := ReadConfigFile(jsonFile)
+	assert.Nil(t, err)
+	assert.Equal(t, want, config)
+
+	yamlFile := writeTempFile(t, "Devices:\n- Name: eth0\n- Name: eth1\nBonds:\n- Name: bond0\n  Mode: 1\n  Devs: [eth0, eth1]\n  IpNets: [10.0.0.1/24]\n")
+	defer os.Remove(yamlFile)
+	config, err = ReadConfigFile(yamlFile)
+	assert.Nil(t, err)
+	assert.Equal(t, want, config)
+
+	badFile := writeTempFile(t, "Bonds: [")
+	defer os.Remove(badFile)
+	_, err = ReadConfigFile(badFile)
+	assert.Error(t, err)
+}
+
+func TestRunOfflineValidate(t *testing.T) {
+	old := currentDaemonConfig()
+	defer applyDaemonConfig(old)
+	defer auditLog.Set("", 0, 0, 0)
+
+	good := writeTempFile(t, `{"Devices":[{"Name":"eth0"}],"Vlans":[{"Name":"vlan100","Parent":"eth0","Tag":100}]}`)
+	defer os.Remove(good)
+	bad := writeTempFile(t, `{"Devices":[{"Name":"eth0"}],"Bonds":[{"Name":"bond0","Mode":9,"Devs":["eth0"]}]}`)
+	defer os.Remove(bad)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, RunOffline([]string{"validate", "-config", "/dev/null", "-f", good}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "is valid")
+	assert.Equal(t, 1, RunOffline([]string{"validate", "-config", "/dev/null", "-f", bad}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), ErrBondMode.Error())
+	assert.Equal(t, 2, RunOffline([]string{"plan", "-config", "/dev/null"}, &stdout, &stderr))
+	assert.True(t, isOfflineCommand("apply"))
+	assert.False(t, isOfflineCommand("-listen"))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// wait a while after a netlink update, so that a burst of updates only triggers one check
+const settleTime = time.Second
+
+var reconciler = NewReconciler(30*time.Second, 10*time.Minute)
+
+// Reconciler periodically compares the system with the database and re-applies the drifted parts,
+// a kernel link/address event triggers a check immediately, or after the backoff while the checks fail
+type Reconciler struct {
+	mu             sync.Mutex
+	enabled        bool
+	paused         bool
+	interval       time.Duration
+	maxBackoff     time.Duration
+	failures       int
+	corrections    int
+	lastCheck      time.Time
+	lastCorrection time.Time
+	lastError      string
+	lastDrifts     []Drift
+	trigger        chan struct{}
+}
+
+type ReconcileStatus struct {
+	Enabled        bool
+	Paused         bool
+	Interval       string
+	MaxBackoff     string
+	Failures       int
+	Corrections    int
+	LastCheck      time.Time
+	LastCorrection time.Time
+	LastError      string
+	LastDrifts     []Drift
+}
+
+func NewReconciler(interval time.Duration, maxBackoff time.Duration) *Reconciler {
+	return &Reconciler{interval: interval, maxBackoff: maxBackoff, trigger: make(chan struct{}, 1)}
+}
+
+// Run never returns, the checks are skipped while disabled or paused
+func (r *Reconciler) Run() {
+	go r.watch()
+	for {
+		select {
+		case <-time.After(r.nextWait()):
+		case <-r.trigger:
+			time.Sleep(r.triggerWait())
+		}
+		if r.active() {
+			r.reconcile()
+		}
+	}
+}
+
+// trigger a check on every kernel link or address event
+func (r *Reconciler) watch() {
+	ch := events.Subscribe()
+	for e := range ch {
+		switch e.Type {
+		case CONFIG_CHANGED, APPLY_STARTED, APPLY_FINISHED, DRIFT_CORRECTED:
+			continue
+		}
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *Reconciler) reconcile() {
+	applyLock.Lock()
+	defer applyLock.Unlock()
+
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		r.done(nil, err)
+		return
+	}
+	userConfig = resolveDevs(userConfig)
+	// renamed links after a reboot would be drifts that can not be fixed otherwise
+	if err := renameDevices(userConfig.Devices); err != nil {
+		r.done(nil, err)
+		return
+	}
+	sysConfig, err := GetConfigFromSys()
+	if err != nil {
+		r.done(nil, err)
+		return
+	}
+
+	drifts := Diff(userConfig, sysConfig)
+	driftCount.Set(float64(len(drifts)))
+	if len(drifts) == 0 {
+		r.done(nil, nil)
+		return
+	}
+	err = applyDrift(userConfig, drifts)
+	r.done(drifts, err)
+	record := AuditRecord{Principal: "reconciler", Resource: "reconcile", Changes: drifts, Status: err == nil, Code: http.StatusOK, Message: "纠正配置偏差成功"}
+	if err != nil {
+		record.Code, record.Message = http.StatusInternalServerError, "纠正配置偏差失败."+err.Error()
+	}
+	auditLog.Record(record)
+}
+
+func (r *Reconciler) done(drifts []Drift, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCheck = time.Now()
+	if err != nil {
+		r.failures++
+		r.lastError = err.Error()
+		log.WithError(err).WithField("Failures", r.failures).Error("Reconcile failed")
+		return
+	}
+
+	r.failures = 0
+	r.lastError = ""
+	if len(drifts) > 0 {
+		r.corrections++
+		r.lastCorrection = r.lastCheck
+		r.lastDrifts = drifts
+		log.WithField("Drifts", drifts).Warn("纠正配置偏差")
+		events.Publish(Event{Type: DRIFT_CORRECTED, Detail: drifts})
+	}
+}
+
+// back off exponentially on repeated failures
+func (r *Reconciler) nextWait() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wait := r.interval
+	for i := 0; i < r.failures && wait < r.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.maxBackoff {
+		wait = r.maxBackoff
+	}
+	return wait
+}
+
+// a failing apply makes its own link and address events, they do not cut the backoff short
+func (r *Reconciler) triggerWait() time.Duration {
+	r.mu.Lock()
+	failing := r.failures > 0
+	r.mu.Unlock()
+	if wait := r.nextWait(); failing && wait > settleTime {
+		return wait
+	}
+	return settleTime
+}
+
+func (r *Reconciler) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enabled && !r.paused
+}
+
+func (r *Reconciler) Set(enabled bool, interval time.Duration, maxBackoff time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enabled = enabled
+	if interval > 0 {
+		r.interval = interval
+	}
+	if maxBackoff > 0 {
+		r.maxBackoff = maxBackoff
+	}
+}
+
+func (r *Reconciler) Pause(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = paused
+}
+
+func (r *Reconciler) Status() ReconcileStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReconcileStatus{
+		Enabled:        r.enabled,
+		Paused:         r.paused,
+		Interval:       r.interval.String(),
+		MaxBackoff:     r.maxBackoff.String(),
+		Failures:       r.failures,
+		Corrections:    r.corrections,
+		LastCheck:      r.lastCheck,
+		LastCorrection: r.lastCorrection,
+		LastError:      r.lastError,
+		LastDrifts:     r.lastDrifts,
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcilerBackoff(t *testing.T) {
+	r := NewReconciler(10*time.Second, time.Minute)
+	assert.Equal(t, 10*time.Second, r.nextWait())
+
+	assert.Equal(t, settleTime, r.triggerWait())
+
+	r.done(nil, assert.AnError)
+	assert.Equal(t, 20*time.Second, r.nextWait())
+	assert.Equal(t, 20*time.Second, r.triggerWait())
+	r.done(nil, assert.AnError)
+	r.done(nil, assert.AnError)
+	assert.Equal(t, time.Minute, r.nextWait())
+	assert.Equal(t, 3, r.Status().Failures)
+
+	drifts := []Drift{{Kind: LINK, Action: ADDED, Type: BRIDGE, Name: "br0"}}
+	r.done(drifts, nil)
+	assert.Equal(t, 10*time.Second, r.nextWait())
+	assert.Equal(t, settleTime, r.triggerWait())
+	assert.Equal(t, 1, r.Status().Corrections)
+	assert.Equal(t, drifts, r.Status().LastDrifts)
+}
+
+func TestReconcilerActive(t *testing.T) {
+	r := NewReconciler(10*time.Second, time.Minute)
+	assert.False(t, r.active())
+
+	r.Set(true, 0, 0)
+	assert.True(t, r.active())
+	assert.Equal(t, "10s", r.Status().Interval)
+
+	r.Pause(true)
+	assert.False(t, r.active())
+	r.Pause(false)
+	assert.True(t, r.active())
+}
+
+func TestReconcileJSONParam(t *testing.T) {
+	get := func(body string) (reconcileParam, error) {
+		return getReconcileJSONParam(httptest.NewRequest("PUT", "/network/reconcile", strings.NewReader(body)))
+	}
+	r, err := get(`{"Interval": "1m"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Enabled)
+	assert.Equal(t, time.Minute, r.interval)
+	assert.Equal(t, time.Duration(0), r.maxBackoff)
+
+	r, err = get(`{"Enabled": false, "MaxBackoff": "5m"}`)
+	assert.Nil(t, err)
+	assert.False(t, *r.Enabled)
+	assert.Equal(t, 5*time.Minute, r.maxBackoff)
+
+	_, err = get(`{"Interval": "often"}`)
+	assert.Error(t, err)
+	_, err = get(`{"MaxBackoff": "-1s"}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	VIEWER   = "viewer"   // may only read
+	EDITOR   = "editor"   // may also change the config in database
+	OPERATOR = "operator" // may also apply the config to system
+)
+
+var roleLevel = map[string]int{VIEWER: 1, EDITOR: 2, OPERATOR: 3}
+
+// the paths that change the system rather than the database, no matter which method
+var operatorPaths = []string{"/network/apply", "/network/init", "/network/reconcile", "/network/audit", "/network/link"}
+
+// LoadRoles reads one "principal role [scope,scope...]" per line, a scope is an interface name pattern like vlan2*
+func LoadRoles(file string) (map[string]Principal, error) {
+	lines, err := readAuthFile(file)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]Principal)
+	for _, fields := range lines {
+		if len(fields) < 2 || roleLevel[fields[1]] == 0 {
+			return nil, errors.New("Role file " + file + " should have lines like: principal viewer|editor|operator [scopes]")
+		}
+		p := Principal{Name: fields[0], Role: fields[1]}
+		if len(fields) > 2 {
+			p.Scopes = strings.Split(fields[2], ",")
+		}
+		roles[fields[0]] = p
+	}
+	return roles, nil
+}
+
+// attach the role to principal and check whether it may access the request
+func (h *AuthHandler) authorize(p *Principal, req *http.Request) error {
+	if h.roles == nil {
+		p.Role = OPERATOR
+		return nil
+	}
+	binding, ok := h.roles[p.Name]
+	if !ok {
+		return ErrForbidden
+	}
+	p.Role, p.Scopes = binding.Role, binding.Scopes
+
+	role := requiredRole(req)
+	if roleLevel[p.Role] < roleLevel[role] {
+		return ErrForbidden
+	}
+	// scopes only limit changes
+	if len(p.Scopes) == 0 || role == VIEWER {
+		return nil
+	}
+	names, ok := requestedNames(req)
+	if !ok {
+		return ErrForbidden
+	}
+	for _, name := range names {
+		if !inScopes(name, p.Scopes) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+func requiredRole(req *http.Request) string {
+	for _, p := range operatorPaths {
+		if req.URL.Path == p || strings.HasPrefix(req.URL.Path, p+"/") {
+			if req.Method == "GET" && p == "/network/reconcile" {
+				return VIEWER
+			}
+			return OPERATOR
+		}
+	}
+	if req.Method == "GET" {
+		return VIEWER
+	}
+	return EDITOR
+}
+
+// the interfaces a request changes or builds on: the name in path, or the Name, Devs, Parent, Dev and Peer in body.
+// false if it is not about specific interfaces, eg: replacing the whole config
+func requestedNames(req *http.Request) ([]string, bool) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// pausing and resuming the reconciler is not about an interface like the reconciler itself
+	if len(parts) == 3 && parts[0] == "network" && parts[1] != "reconcile" {
+		return []string{parts[2]}, true
+	}
+	if len(parts) == 4 && parts[0] == "network" && parts[1] == "link" {
+		return []string{parts[2]}, true
+	}
+	if len(parts) != 2 || parts[0] != "network" || parts[1] == "config" || parts[1] == "reconcile" {
+		return nil, false
+	}
+
+	// read the body and put it back for the handler
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var param struct {
+		Name      string
+		Devs      []string
+		Parent    string // vlan, macvlan and ipvlan
+		Dev       string // underlay of vxlan and tunnel
+		Peer      string // veth
+		PeerNetns string
+		PeerPid   int
+	}
+	if err := json.Unmarshal(body, &param); err != nil || param.Name == "" {
+		return nil, false
+	}
+	names := append([]string{param.Name}, param.Devs...)
+	for _, name := range []string{param.Parent, param.Dev} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	// a peer moved into another namespace does not take a name in the host
+	if param.Peer != "" && param.PeerNetns == "" && param.PeerPid == 0 {
+		names = append(names, param.Peer)
+	}
+	return names, true
+}
+
+func inScopes(name string, scopes []string) bool {
+	for _, scope := range scopes {
+		if ok, _ := path.Match(scope, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRoles(t *testing.T) {
+	file := writeTempFile(t, "alice operator\nbob viewer\ntenant1 editor vlan2*,eth4\n")
+	defer os.Remove(file)
+	roles, err := LoadRoles(file)
+	assert.Nil(t, err)
+	assert.Equal(t, Principal{Name: "tenant1", Role: EDITOR, Scopes: []string{"vlan2*", "eth4"}}, roles["tenant1"])
+
+	bad := writeTempFile(t, "alice root\n")
+	defer os.Remove(bad)
+	_, err = LoadRoles(bad)
+	assert.Error(t, err)
+}
+
+func TestAuthorize(t *testing.T) {
+	h := &AuthHandler{roles: map[string]Principal{
+		"alice":   {Role: OPERATOR},
+		"bob":     {Role: VIEWER},
+		"carol":   {Role: EDITOR},
+		"tenant1": {Role: OPERATOR, Scopes: []string{"vlan2*", "eth1"}},
+		"tenant2": {Role: OPERATOR, Scopes: []string{"*"}},
+	}}
+	check := func(name, method, url, body string) error {
+		return h.authorize(&Principal{Name: name}, httptest.NewRequest(method, url, strings.NewReader(body)))
+	}
+
+	assert.Nil(t, check("bob", "GET", "/network/config", ""))
+	assert.Equal(t, ErrForbidden, check("bob", "POST", "/network/vlan", `{"Name":"vlan200"}`))
+	assert.Equal(t, ErrForbidden, check("bob", "GET", "/network/apply", ""))
+	assert.Nil(t, check("bob", "GET", "/network/reconcile", ""))
+	assert.Equal(t, ErrForbidden, check("mallory", "GET", "/network/config", ""))
+
+	assert.Nil(t, check("carol", "PUT", "/network/config", "{}"))
+	assert.Equal(t, ErrForbidden, check("carol", "GET", "/network/init", ""))
+	assert.Equal(t, ErrForbidden, check("carol", "POST", "/network/reconcile/pause", ""))
+	assert.Nil(t, check("alice", "GET", "/network/init", ""))
+	assert.Nil(t, check("alice", "GET", "/network/audit", ""))
+	assert.Equal(t, ErrForbidden, check("carol", "GET", "/network/audit", ""))
+	assert.Nil(t, check("alice", "POST", "/network/link/eth0/down", ""))
+	assert.Equal(t, ErrForbidden, check("carol", "POST", "/network/link/eth0/down", ""))
+
+	assert.Nil(t, check("tenant1", "POST", "/network/vlan", `{"Name":"vlan200","Parent":"eth1","Tag":200}`))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/vlan", `{"Name":"vlan200","Parent":"eth0","Tag":200}`))
+	assert.Equal(t, ErrForbidden, check("tenant1", "PUT", "/network/macvlan", `{"Name":"vlan2mv","Parent":"eth0"}`))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/vxlan", `{"Name":"vlan2vx","Vni":2,"Dev":"eth0"}`))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/veth", `{"Name":"vlan2v0","Peer":"veth1"}`))
+	assert.Nil(t, check("tenant1", "POST", "/network/veth", `{"Name":"vlan2v0","Peer":"vlan2v1"}`))
+	assert.Nil(t, check("tenant1", "POST", "/network/veth", `{"Name":"vlan2v0","Peer":"eth0","PeerNetns":"web"}`))
+	assert.Nil(t, check("tenant1", "DELETE", "/network/vlan/vlan201", ""))
+	assert.Nil(t, check("tenant1", "GET", "/network/bond/bond0", ""))
+	assert.Equal(t, ErrForbidden, check("tenant1", "DELETE", "/network/vlan/vlan300", ""))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/bond/", `{"Name":"vlan2bond","Devs":["eth0"]}`))
+	assert.Equal(t, ErrForbidden, check("tenant1", "PATCH", "/network/config", "{}"))
+	assert.Equal(t, ErrForbidden, check("tenant1", "GET", "/network/apply", ""))
+	assert.Nil(t, check("tenant1", "POST", "/network/link/vlan200/up", ""))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/link/eth0/up", ""))
+	assert.Equal(t, ErrForbidden, check("tenant1", "POST", "/network/reconcile/pause", ""))
+	assert.Equal(t, ErrForbidden, check("tenant2", "POST", "/network/reconcile/pause", ""))
+	assert.Equal(t, ErrForbidden, check("tenant2", "POST", "/network/reconcile/resume", ""))
+	assert.Nil(t, check("tenant2", "DELETE", "/network/vlan/vlan300", ""))
+}
+
+func TestAuthorizeKeepBody(t *testing.T) {
+	h := &AuthHandler{roles: map[string]Principal{"tenant1": {Role: EDITOR, Scopes: []string{"vlan2*"}}}}
+	req := httptest.NewRequest("POST", "/network/vlan", strings.NewReader(`{"Name":"vlan200"}`))
+	p := &Principal{Name: "tenant1"}
+	assert.Nil(t, h.authorize(p, req))
+	assert.Equal(t, EDITOR, p.Role)
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"Name":"vlan200"}`, string(body))
+
+	h.roles = nil
+	assert.Nil(t, h.authorize(p, req))
+	assert.Equal(t, OPERATOR, p.Role)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// closed when shutdown starts, long running requests like event streams return on it
+var shuttingDown = make(chan struct{})
+
+// HandleSignals reloads the daemon config on SIGHUP, shuts down on SIGTERM or SIGINT and returns when done
+func HandleSignals(server *http.Server, auth *AuthHandler, reloader *CertReloader, args []string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range ch {
+		if sig == syscall.SIGHUP {
+			ReloadDaemonConfig(args, auth, reloader)
+			continue
+		}
+		log.WithField("Signal", sig.String()).Info("关闭服务")
+		c := currentDaemonConfig()
+		Shutdown(server, c.duration(c.ShutdownTimeout))
+		return
+	}
+}
+
+// Shutdown stops accepting requests, waits for the running ones and the running apply within timeout,
+// then flushes the data source and audit log. applyLock is kept so that no apply starts before exit
+func Shutdown(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	close(shuttingDown)
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.WithError(err).Error("Wait for running requests failed")
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		applyLock.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		err = errors.New("Apply is still running after " + timeout.String())
+		log.WithError(err).Error("Wait for apply failed")
+	}
+
+	if dsErr := saveDataSource(); dsErr != nil {
+		log.WithError(dsErr).Error("Flush data source failed")
+		err = dsErr
+	}
+	if auditErr := auditLog.Close(); auditErr != nil {
+		log.WithError(auditErr).Error("Flush audit log failed")
+		err = auditErr
+	}
+	return err
+}
+
+// ReloadDaemonConfig reads the config again and applies everything but the settings only read at start,
+// the old config is kept if the new one is invalid
+func ReloadDaemonConfig(args []string, auth *AuthHandler, reloader *CertReloader) error {
+	c, _, err := LoadDaemonConfig(args)
+	if err != nil {
+		log.WithError(err).Error("Reload daemon config failed")
+		return err
+	}
+	auths, roles, err := LoadAccess(c)
+	if err != nil {
+		log.WithError(err).Error("Reload daemon config failed")
+		return err
+	}
+	if changed := c.keepStartupSettings(currentDaemonConfig()); len(changed) > 0 {
+		log.WithField("Settings", changed).Warn("These settings need restart to take effect")
+	}
+
+	applyDaemonConfig(c)
+	auth.Reload(auths, roles)
+	if reloader != nil {
+		if err := reloader.Reload(); err != nil {
+			return err
+		}
+		log.Info("重新加载证书")
+	}
+	log.Info("重新加载配置")
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	defer func() { shuttingDown = make(chan struct{}) }()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		resp.Write([]byte("done"))
+	})}
+	go Serve(server, []net.Listener{l}, nil)
+
+	// an apply started by reconciler
+	applyLock.Lock()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		applyLock.Unlock()
+	}()
+
+	respCh := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		respCh <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Nil(t, Shutdown(server, 5*time.Second))
+	assert.Equal(t, "done", <-respCh)
+	// no apply starts after shutdown
+	assert.False(t, applyLock.TryLock())
+	applyLock.Unlock()
+
+	_, err = http.Get("http://" + l.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestReloadDaemonConfig(t *testing.T) {
+	old := currentDaemonConfig()
+	defer func() {
+		applyDaemonConfig(old)
+		auditLog.Set("", 0, 0, 0)
+	}()
+
+	file := writeTempFile(t, "host_id: reloaded\naudit_file: \"\"\nlisten: [\":8080\"]\ndata_source: /tmp/ds.json\n")
+	defer os.Remove(file)
+	auth := NewAuthHandler(http.NotFoundHandler(), nil, nil)
+	assert.Nil(t, ReloadDaemonConfig([]string{"-config", file}, auth, nil))
+	c := currentDaemonConfig()
+	assert.Equal(t, "reloaded", c.HostId)
+	assert.Equal(t, "reloaded", getHostId())
+	// need restart
+	assert.Equal(t, old.Listen, c.Listen)
+	assert.Equal(t, old.DataSource, c.DataSource)
+
+	// the old config is kept if the new one is broken
+	ioutil.WriteFile(file, []byte("host_id: broken\naudit_file: \"\"\nlog_level: loud\n"), 0600)
+	assert.Error(t, ReloadDaemonConfig([]string{"-config", file}, auth, nil))
+	assert.Equal(t, "reloaded", getHostId())
+}
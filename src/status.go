@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// the operational state of a link in system, used for troubleshooting
+type LinkStatus struct {
+	Index        int
+	Name         string
+	Type         string
+	AdminUp      bool
+	OperState    string
+	Carrier      bool
+	Mtu          int
+	HardwareAddr string
+	Master       string `json:",omitempty"`
+	Speed        int    `json:",omitempty"` // Mb/s, only for devices with carrier
+	Duplex       string `json:",omitempty"`
+	Statistics   LinkStatistics
+	Bond         *BondStatus      `json:",omitempty"`
+	BondSlave    *BondSlaveStatus `json:",omitempty"`
+}
+
+type LinkStatistics struct {
+	RxBytes   uint64
+	TxBytes   uint64
+	RxPackets uint64
+	TxPackets uint64
+	RxErrors  uint64
+	TxErrors  uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
+type BondStatus struct {
+	Mode        string
+	ActiveSlave string `json:",omitempty"`
+	Slaves      []string
+}
+
+type BondSlaveStatus struct {
+	State            string
+	MiiStatus        string
+	LinkFailureCount uint32
+	PermHardwareAddr string
+}
+
+// get every link's status from system
+func GetStatusFromSys() ([]LinkStatus, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		log.WithError(err).Error("Get link list fail")
+		return nil, err
+	}
+
+	names := make(map[int]string)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	devMap := getSlaveList(links)
+
+	var status []LinkStatus
+	for _, link := range links {
+		status = append(status, linkStatus(link, names, devMap))
+	}
+	return status, nil
+}
+
+func linkStatus(link netlink.Link, names map[int]string, devMap map[int][]string) LinkStatus {
+	attrs := link.Attrs()
+	s := LinkStatus{
+		Index:        attrs.Index,
+		Name:         attrs.Name,
+		Type:         link.Type(),
+		AdminUp:      attrs.Flags&net.FlagUp != 0,
+		OperState:    attrs.OperState.String(),
+		Carrier:      attrs.OperState == netlink.OperUp,
+		Mtu:          attrs.MTU,
+		HardwareAddr: attrs.HardwareAddr.String(),
+		Master:       names[attrs.MasterIndex],
+	}
+
+	if stats := attrs.Statistics; stats != nil {
+		s.Statistics = LinkStatistics{
+			RxBytes:   uint64(stats.RxBytes),
+			TxBytes:   uint64(stats.TxBytes),
+			RxPackets: uint64(stats.RxPackets),
+			TxPackets: uint64(stats.TxPackets),
+			RxErrors:  uint64(stats.RxErrors),
+			TxErrors:  uint64(stats.TxErrors),
+			RxDropped: uint64(stats.RxDropped),
+			TxDropped: uint64(stats.TxDropped),
+		}
+	}
+
+	// speed and duplex are only readable when the device has carrier
+	if link.Type() == DEVICE && s.Carrier {
+		s.Speed, s.Duplex = getSpeedDuplex(attrs.Name)
+	}
+
+	if bond, ok := link.(*netlink.Bond); ok {
+		s.Bond = &BondStatus{Mode: bond.Mode.String(), ActiveSlave: names[bond.ActiveSlave], Slaves: devMap[attrs.Index]}
+	}
+	if slave, ok := attrs.Slave.(*netlink.BondSlave); ok {
+		s.BondSlave = &BondSlaveStatus{
+			State:            slave.State.String(),
+			MiiStatus:        slave.MiiStatus.String(),
+			LinkFailureCount: slave.LinkFailureCount,
+			PermHardwareAddr: slave.PermHardwareAddr.String(),
+		}
+	}
+	return s
+}
+
+// read from sysfs since netlink does not carry ethtool settings, 0 and "" if unknown
+func getSpeedDuplex(name string) (int, string) {
+	var speed int
+	var duplex string
+	if data, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "speed")); err == nil {
+		if s, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && s > 0 {
+			speed = s
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "duplex")); err == nil {
+		if d := strings.TrimSpace(string(data)); d != "unknown" {
+			duplex = d
+		}
+	}
+	return speed, duplex
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestLinkStatus(t *testing.T) {
+	names := map[int]string{2: "eth0", 3: "eth1", 5: "bond0"}
+	devMap := map[int][]string{5: {"eth0", "eth1"}}
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+
+	bond := netlink.NewLinkBond(netlink.LinkAttrs{Index: 5, Name: "bond0", MTU: 9000, HardwareAddr: mac, Flags: net.FlagUp, OperState: netlink.OperUp,
+		Statistics: &netlink.LinkStatistics{RxBytes: 100, TxBytes: 200, RxDropped: 3}})
+	bond.Mode = netlink.BOND_MODE_ACTIVE_BACKUP
+	bond.ActiveSlave = 3
+	s := linkStatus(bond, names, devMap)
+	assert.Equal(t, "bond0", s.Name)
+	assert.Equal(t, BOND, s.Type)
+	assert.True(t, s.AdminUp)
+	assert.True(t, s.Carrier)
+	assert.Equal(t, 9000, s.Mtu)
+	assert.Equal(t, "52:54:00:12:34:56", s.HardwareAddr)
+	assert.Equal(t, LinkStatistics{RxBytes: 100, TxBytes: 200, RxDropped: 3}, s.Statistics)
+	assert.Equal(t, &BondStatus{Mode: "active-backup", ActiveSlave: "eth1", Slaves: []string{"eth0", "eth1"}}, s.Bond)
+
+	dev := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eth0", MasterIndex: 5, OperState: netlink.OperDown,
+		Slave: &netlink.BondSlave{State: netlink.BondStateBackup, MiiStatus: netlink.BondLinkDown, LinkFailureCount: 2}}}
+	s = linkStatus(dev, names, devMap)
+	assert.False(t, s.AdminUp)
+	assert.False(t, s.Carrier)
+	assert.Equal(t, "down", s.OperState)
+	assert.Equal(t, "bond0", s.Master)
+	assert.Equal(t, 0, s.Speed)
+	assert.Nil(t, s.Bond)
+	assert.Equal(t, uint32(2), s.BondSlave.LinkFailureCount)
+	assert.Equal(t, "BACKUP", s.BondSlave.State)
+}
+
+func TestGetSpeedDuplex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sysclassnet")
+	defer os.RemoveAll(dir)
+	defer func(old string) { sysClassNet = old }(sysClassNet)
+	sysClassNet = dir
+	os.MkdirAll(filepath.Join(dir, "eth0"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "eth0", "speed"), []byte("10000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "eth0", "duplex"), []byte("full\n"), 0644)
+
+	speed, duplex := getSpeedDuplex("eth0")
+	assert.Equal(t, 10000, speed)
+	assert.Equal(t, "full", duplex)
+	speed, duplex = getSpeedDuplex("eth1")
+	assert.Equal(t, 0, speed)
+	assert.Equal(t, "", duplex)
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	GRE    = "gre"
+	GRETAP = "gretap"
+	IPIP   = "ipip"
+	SIT    = "sit"
+	IP6TNL = "ip6tnl"
+)
+
+// the link types of the tunnel modes in system, gre over IPv6 is ip6gre
+var tunnelModes = map[string]string{
+	GRE: GRE, "ip6gre": GRE, GRETAP: GRETAP, "ip6gretap": GRETAP, IPIP: IPIP, SIT: SIT, IP6TNL: IP6TNL,
+}
+
+// created by the kernel along with the modules, they can not be deleted
+var fallbackTunnels = map[string]bool{
+	"gre0": true, "gretap0": true, "erspan0": true, "tunl0": true, "sit0": true, "ip6tnl0": true, "ip6gre0": true,
+}
+
+func isTunnel(link netlink.Link) bool {
+	return tunnelModes[link.Type()] != "" && !fallbackTunnels[link.Attrs().Name]
+}
+
+func tunnelList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Tunnel失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Tunnels, Status: true, Message: "获取Tunnel成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func tunnelGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Tunnel失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if tun, ok := findTunnel(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Tunnel失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: tun, Status: true, Message: "获取Tunnel成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func tunnelAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	tun, err := getTunnelJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Tunnel添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := TunnelAdd(tun); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Tunnel添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Tunnel", tunnelSettings(tun)).Info("添加Tunnel")
+		configMutations.WithLabelValues(TUNNEL).Inc()
+		rm = ResponseMessage{Status: true, Message: "Tunnel添加成功", Code: http.StatusCreated}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func tunnelUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	tun, err := getTunnelJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Tunnel更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := TunnelUpdate(tun); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Tunnel更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Tunnel", tunnelSettings(tun)).Info("更新Tunnel")
+		configMutations.WithLabelValues(TUNNEL).Inc()
+		rm = ResponseMessage{Status: true, Message: "Tunnel更新成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func tunnelDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := TunnelDel(name); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Tunnel删除失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.Info("删除Tunnel:" + name)
+		configMutations.WithLabelValues(TUNNEL).Inc()
+		rm = ResponseMessage{Status: true, Message: "Tunnel删除成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func TunnelAdd(tun Tunnel) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertTunnel(tunnelSettings(tun), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new tunnel is validated against the config without the old one, nothing is stored if it is invalid
+func TunnelUpdate(tun Tunnel) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findTunnel(tun.Name, userConfig)
+	tun = tunnelSettings(tun)
+	tun.IpNets = old.IpNets
+	removeTunnel(tun.Name, &userConfig)
+	if err := insertTunnel(tun, &userConfig); err != nil {
+		log.WithError(err).Error("Tunnel " + tun.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func TunnelDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	removeTunnel(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// validate and add the tunnel to userConfig, shared by add and update
+func insertTunnel(tun Tunnel, userConfig *Config) error {
+	if isLinkAlreadyExists(tun.Name, *userConfig) {
+		log.WithError(ErrNameUsed).Error("Name:" + tun.Name)
+		return ErrNameUsed
+	}
+	userConfig.Tunnels = append(userConfig.Tunnels, tun)
+	if err := validateTunnel(tun, *userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeTunnel(name string, userConfig *Config) {
+	for i, tun := range userConfig.Tunnels {
+		if tun.Name == name {
+			userConfig.Tunnels = append(userConfig.Tunnels[:i], userConfig.Tunnels[i+1:]...)
+			break
+		}
+	}
+}
+
+// the endpoints are of the family of the mode and Local is an address of an interface in config or in system
+func validateTunnel(tun Tunnel, config Config) error {
+	if tun.Mode == "" || tunnelModes[tun.Mode] != tun.Mode {
+		return ErrTunnelMode
+	}
+	remote, local := net.ParseIP(tun.Remote), net.ParseIP(tun.Local)
+	if remote == nil || tun.Local != "" && local == nil {
+		log.WithError(ErrTunnelAddr).Error("Tunnel:" + tun.Name)
+		return ErrTunnelAddr
+	}
+	v4 := remote.To4() != nil
+	if local != nil && (local.To4() != nil) != v4 ||
+		(tun.Mode == IPIP || tun.Mode == SIT) && !v4 || tun.Mode == IP6TNL && v4 {
+		log.WithError(ErrTunnelAddr).Error("Tunnel:" + tun.Name)
+		return ErrTunnelAddr
+	}
+	if local != nil && !hasAddress(config, tun.Name, local) && !hasSysAddress(tun.Name, local) {
+		log.WithError(ErrTunnelLocal).Error("Local:" + tun.Local)
+		return ErrTunnelLocal
+	}
+
+	if tun.Key != 0 && tun.Mode != GRE && tun.Mode != GRETAP || tun.Key < 0 || int64(tun.Key) > math.MaxUint32 {
+		return ErrTunnelKey
+	}
+	if tun.Ttl < 0 || tun.Ttl > 255 {
+		return ErrTunnelTtl
+	}
+	if tun.HardwareAddr != "" && tun.Mode != GRETAP {
+		return ErrTunnelMac
+	}
+	if tun.Dev != "" && !isLinkAlreadyExists(tun.Dev, config) {
+		log.WithError(ErrDevsNull).Error("Dev:" + tun.Dev)
+		return ErrDevsNull
+	}
+	// checked when the config is changed only, apply builds the tunnels before the routes to the remote are up
+	if _, err := routeGet(remote); err != nil {
+		log.WithError(err).Error("Get route to " + tun.Remote + " failed")
+		return ErrTunnelRemote
+	}
+	return validateIpNets(tun.Name, tun.IpNets)
+}
+
+// replaced in tests, there are no routes in the test namespace
+var routeGet = netlink.RouteGet
+
+// ip is an address of an interface other than name in config
+func hasAddress(config Config, name string, ip net.IP) bool {
+	for _, i := range getIPs(config) {
+		if i.Name == name {
+			continue
+		}
+		for _, ipNet := range i.Ip {
+			if addr, err := netlink.ParseAddr(ipNet); err == nil && addr.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ip is an address of an interface other than name in system, like the ones from dhcp or on the admin interface
+func hasSysAddress(name string, ip net.IP) bool {
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		log.WithError(err).Error("List addresses failed")
+		return false
+	}
+	for _, addr := range addrs {
+		if !addr.IP.Equal(ip) {
+			continue
+		}
+		if link, err := netlink.LinkByIndex(addr.LinkIndex); err == nil && link.Attrs().Name != name {
+			return true
+		}
+	}
+	return false
+}
+
+func tunnelSettings(tun Tunnel) Tunnel {
+	return Tunnel{Name: tun.Name, Mode: tun.Mode, Local: tun.Local, Remote: tun.Remote, Key: tun.Key, Ttl: tun.Ttl, Dev: tun.Dev,
+		IpNets: tun.IpNets, Mtu: tun.Mtu, HardwareAddr: tun.HardwareAddr, Alias: tun.Alias, State: tun.State}
+}
+
+func findTunnel(name string, config Config) (Tunnel, bool) {
+	for _, tun := range config.Tunnels {
+		if tun.Name == name {
+			return tun, true
+		}
+	}
+	return Tunnel{}, false
+}
+
+func getTunnelJSONParam(req *http.Request) (Tunnel, error) {
+	req.ParseForm()
+	var tun Tunnel
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &tun); err != nil {
+		return Tunnel{}, errors.New("用户输入参数格式有误")
+	}
+	if tun.Name == "" {
+		return Tunnel{}, errors.New("Tunnel's Name can not be empty")
+	}
+	if tun.Remote == "" {
+		return Tunnel{}, errors.New("Tunnel's Remote can not be empty")
+	}
+	return tun, nil
+}
+
+func buildTunnel(tunnels []Tunnel) error {
+	for _, tun := range tunnels {
+		if err := addTunnel(tun); err != nil {
+			log.WithError(err).Error("add tunnel failed")
+			return err
+		}
+		if err := setLinkSettings(tun.Name, tun.Mtu, tun.HardwareAddr, tun.Alias); err != nil {
+			log.WithError(err).Error("tunnel set mtu, mac or alias failed")
+			return err
+		}
+		for _, ipNet := range tun.IpNets {
+			if err := setIP(tun.Name, ipNet); err != nil {
+				log.WithError(err).Error("tunnel add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addTunnel(tun Tunnel) error {
+	var dev uint32
+	if tun.Dev != "" {
+		devIndex, err := getIndexByName(tun.Dev)
+		if err != nil {
+			log.WithError(err).Error("get underlay device " + tun.Dev + "'s index fail ")
+			return err
+		}
+		dev = uint32(devIndex)
+	}
+	attrs := netlink.LinkAttrs{Name: tun.Name}
+	local, remote, ttl, key := net.ParseIP(tun.Local), net.ParseIP(tun.Remote), uint8(tun.Ttl), uint32(tun.Key)
+	// netlink tells gre from ip6gre by Local
+	if local == nil && remote.To4() != nil {
+		local = net.IPv4zero
+	}
+
+	var link netlink.Link
+	switch tun.Mode {
+	case GRE:
+		link = &netlink.Gretun{LinkAttrs: attrs, Link: dev, Local: local, Remote: remote, Ttl: ttl, IKey: key, OKey: key, PMtuDisc: 1}
+	case GRETAP:
+		link = &netlink.Gretap{LinkAttrs: attrs, Link: dev, Local: local, Remote: remote, Ttl: ttl, IKey: key, OKey: key, PMtuDisc: 1}
+	case IPIP:
+		link = &netlink.Iptun{LinkAttrs: attrs, Link: dev, Local: local, Remote: remote, Ttl: ttl, PMtuDisc: 1}
+	case SIT:
+		link = &netlink.Sittun{LinkAttrs: attrs, Link: dev, Local: local, Remote: remote, Ttl: ttl, PMtuDisc: 1}
+	case IP6TNL:
+		link = &netlink.Ip6tnl{LinkAttrs: attrs, Link: dev, Local: local, Remote: remote, Ttl: ttl}
+	default:
+		return ErrTunnelMode
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		log.WithError(err).Error("Add tunnel " + tun.Name + " fail ")
+		return err
+	}
+	return nil
+}
+
+// the Tunnel of link read back from system, without IpNets and the link settings
+func tunnelOf(link netlink.Link) Tunnel {
+	tun := Tunnel{Index: link.Attrs().Index, Name: link.Attrs().Name, Mode: tunnelModes[link.Type()]}
+	var dev uint32
+	var local, remote net.IP
+	switch l := link.(type) {
+	case *netlink.Gretun:
+		dev, local, remote, tun.Ttl, tun.Key = l.Link, l.Local, l.Remote, int(l.Ttl), int(l.IKey)
+	case *netlink.Gretap:
+		dev, local, remote, tun.Ttl, tun.Key = l.Link, l.Local, l.Remote, int(l.Ttl), int(l.IKey)
+	case *netlink.Iptun:
+		dev, local, remote, tun.Ttl = l.Link, l.Local, l.Remote, int(l.Ttl)
+	case *netlink.Sittun:
+		dev, local, remote, tun.Ttl = l.Link, l.Local, l.Remote, int(l.Ttl)
+	case *netlink.Ip6tnl:
+		dev, local, remote, tun.Ttl = l.Link, l.Local, l.Remote, int(l.Ttl)
+	}
+	if dev != 0 {
+		if d, err := netlink.LinkByIndex(int(dev)); err == nil {
+			tun.Dev = d.Attrs().Name
+		}
+	}
+	if local != nil && !local.IsUnspecified() {
+		tun.Local = local.String()
+	}
+	if remote != nil && !remote.IsUnspecified() {
+		tun.Remote = remote.String()
+	}
+	return tun
+}
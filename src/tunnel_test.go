@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// there are no routes in the test namespace, every remote is reachable unless listed in unreachable
+func fakeRoutes(unreachable ...string) func() {
+	old := routeGet
+	routeGet = func(ip net.IP) ([]netlink.Route, error) {
+		for _, u := range unreachable {
+			if ip.Equal(net.ParseIP(u)) {
+				return nil, errors.New("network is unreachable")
+			}
+		}
+		return []netlink.Route{{Dst: &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}}}, nil
+	}
+	return func() { routeGet = old }
+}
+
+func TestValidateTunnel(t *testing.T) {
+	defer fakeRoutes("198.51.100.1")()
+	config := Config{
+		Devices: []Device{{Name: "eth0", IpNets: []string{"10.0.0.1/24", "2001:db8::1/64"}}},
+		Tunnels: []Tunnel{{Name: "gre1", Mode: GRE, Local: "10.0.0.1", Remote: "192.0.2.1", Key: 7, Ttl: 64, Dev: "eth0"}},
+	}
+	assert.Nil(t, validateConfig(config))
+
+	tun := &config.Tunnels[0]
+	tun.Mode = "ip6gre"
+	assert.Equal(t, ErrTunnelMode, validateConfig(config))
+	tun.Mode = GRE
+
+	tun.Local = "10.0.0.9"
+	assert.Equal(t, ErrTunnelLocal, validateConfig(config))
+	tun.Local = "2001:db8::1"
+	assert.Equal(t, ErrTunnelAddr, validateConfig(config))
+	tun.Remote = "2001:db8:1::1"
+	assert.Nil(t, validateConfig(config))
+	tun.Mode = IP6TNL
+	assert.Equal(t, ErrTunnelKey, validateConfig(config))
+	tun.Key = 0
+	assert.Nil(t, validateConfig(config))
+	tun.Mode = SIT
+	assert.Equal(t, ErrTunnelAddr, validateConfig(config))
+	tun.Mode, tun.Local, tun.Remote = IPIP, "", "192.0.2.1"
+	assert.Nil(t, validateConfig(config))
+
+	tun.Ttl = 256
+	assert.Equal(t, ErrTunnelTtl, validateConfig(config))
+	tun.Ttl = 0
+
+	tun.HardwareAddr = "52:54:00:12:34:56"
+	assert.Equal(t, ErrTunnelMac, validateConfig(config))
+	tun.Mode = GRETAP
+	assert.Nil(t, validateConfig(config))
+
+	tun.Dev = "eth9"
+	assert.Equal(t, ErrDevsNull, validateConfig(config))
+	tun.Dev = ""
+
+	// an address of lo is in system only
+	tun.Local = "127.0.0.1"
+	assert.Nil(t, validateConfig(config))
+	tun.Local = ""
+
+	tun.Remote = "198.51.100.1"
+	assert.Equal(t, ErrTunnelRemote, validateConfig(config))
+	tun.Remote = "192.0.2.1"
+
+	tun.IpNets = []string{"10.1.0.1"}
+	assert.Error(t, validateTunnel(*tun, config))
+}
+
+func TestTunnelUpdate(t *testing.T) {
+	defer fakeRoutes("198.51.100.1")()
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	gre := Tunnel{Name: "gre1", Mode: GRE, Remote: "192.0.2.1", IpNets: []string{"172.16.0.1/30"}}
+	PutToDataSource(Config{Tunnels: []Tunnel{gre}})
+
+	assert.Equal(t, ErrTunnelTtl, TunnelUpdate(Tunnel{Name: "gre1", Mode: GRE, Remote: "192.0.2.1", Ttl: 256}))
+	assert.Equal(t, ErrTunnelMode, TunnelUpdate(Tunnel{Name: "gre1", Mode: "vxlan", Remote: "192.0.2.1"}))
+	assert.Equal(t, ErrTunnelRemote, TunnelUpdate(Tunnel{Name: "gre1", Mode: GRE, Remote: "198.51.100.1"}))
+	config, _ := GetConfigFromDs()
+	assert.Equal(t, []Tunnel{gre}, config.Tunnels)
+
+	// the IPs are kept, they are changed by the IP API
+	assert.Nil(t, TunnelUpdate(Tunnel{Name: "gre1", Mode: GRE, Remote: "192.0.2.2", Key: 7}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Tunnel{{Name: "gre1", Mode: GRE, Remote: "192.0.2.2", Key: 7, IpNets: []string{"172.16.0.1/30"}}}, config.Tunnels)
+}
+
+func TestTunnelOf(t *testing.T) {
+	gre := &netlink.Gretun{LinkAttrs: netlink.LinkAttrs{Index: 5, Name: "gre1"}, Local: net.ParseIP("10.0.0.1"), Remote: net.ParseIP("192.0.2.1"), IKey: 7, Ttl: 64}
+	assert.Equal(t, Tunnel{Index: 5, Name: "gre1", Mode: GRE, Local: "10.0.0.1", Remote: "192.0.2.1", Key: 7, Ttl: 64}, tunnelOf(gre))
+
+	gre.Local, gre.Remote = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8:1::1")
+	assert.Equal(t, "ip6gre", gre.Type())
+	assert.Equal(t, GRE, tunnelOf(gre).Mode)
+
+	sit := &netlink.Sittun{LinkAttrs: netlink.LinkAttrs{Name: "sit1"}, Local: net.IPv4zero, Remote: net.ParseIP("192.0.2.1")}
+	assert.Equal(t, Tunnel{Name: "sit1", Mode: SIT, Remote: "192.0.2.1"}, tunnelOf(sit))
+
+	assert.False(t, isTunnel(&netlink.Sittun{LinkAttrs: netlink.LinkAttrs{Name: "sit0"}}))
+	assert.True(t, isTunnel(sit))
+}
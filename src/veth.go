@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func vethList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Veth失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Veths, Status: true, Message: "获取Veth成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vethGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Veth失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if v, ok := findVeth(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Veth失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: v, Status: true, Message: "获取Veth成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vethAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	v, err := getVethJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Veth添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := VethAdd(v); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Veth添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Veth", vethSettings(v)).Info("添加Veth")
+		configMutations.WithLabelValues(VETH).Inc()
+		rm = ResponseMessage{Status: true, Message: "Veth添加成功", Code: http.StatusCreated}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vethUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	v, err := getVethJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Veth更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := VethUpdate(v); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Veth更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Veth", vethSettings(v)).Info("更新Veth")
+		configMutations.WithLabelValues(VETH).Inc()
+		rm = ResponseMessage{Status: true, Message: "Veth更新成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vethDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := VethDel(name); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Veth删除失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.Info("删除Veth:" + name)
+		configMutations.WithLabelValues(VETH).Inc()
+		rm = ResponseMessage{Status: true, Message: "Veth删除成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func VethAdd(v Veth) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertVeth(vethSettings(v), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new veth is validated against the config without the old one, nothing is stored if it is invalid
+func VethUpdate(v Veth) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them, and the ones of the peer while it stays in the host namespace
+	old, _ := findVeth(v.Name, userConfig)
+	v = vethSettings(v)
+	v.IpNets = old.IpNets
+	if peerInHost(v) && peerInHost(old) && v.Peer == old.Peer {
+		v.PeerIpNets = old.PeerIpNets
+	}
+	removeVeth(v.Name, &userConfig)
+	if err := insertVeth(v, &userConfig); err != nil {
+		log.WithError(err).Error("Veth " + v.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func VethDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	removeVeth(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// validate and add the veth to userConfig, shared by add and update
+func insertVeth(v Veth, userConfig *Config) error {
+	if isLinkAlreadyExists(v.Name, *userConfig) || peerInHost(v) && isLinkAlreadyExists(v.Peer, *userConfig) || v.Name == v.Peer {
+		log.WithError(ErrNameUsed).Error("Name:" + v.Name + " Peer:" + v.Peer)
+		return ErrNameUsed
+	}
+	if err := validateVeth(v); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+
+	userConfig.Veths = append(userConfig.Veths, v)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeVeth(name string, userConfig *Config) {
+	for i, v := range userConfig.Veths {
+		if v.Name == name {
+			userConfig.Veths = append(userConfig.Veths[:i], userConfig.Veths[i+1:]...)
+			break
+		}
+	}
+}
+
+// the peer stays in the host namespace, it is an interface of config like the other end then
+func peerInHost(v Veth) bool {
+	return v.PeerNetns == "" && v.PeerPid == 0
+}
+
+func validateVeth(v Veth) error {
+	if v.Peer == "" {
+		return ErrVethPeer
+	}
+	if v.PeerNetns != "" && v.PeerPid != 0 || v.PeerPid < 0 || strings.Contains(v.PeerNetns, "/") {
+		log.WithError(ErrVethNetns).Error("Veth:" + v.Name)
+		return ErrVethNetns
+	}
+	if err := validateIpNets(v.Name, v.IpNets); err != nil {
+		return err
+	}
+	return validateIpNets(v.Peer, v.PeerIpNets)
+}
+
+func vethSettings(v Veth) Veth {
+	return Veth{Name: v.Name, Peer: v.Peer, PeerNetns: v.PeerNetns, PeerPid: v.PeerPid, IpNets: v.IpNets, PeerIpNets: v.PeerIpNets,
+		Mtu: v.Mtu, HardwareAddr: v.HardwareAddr, Alias: v.Alias, State: v.State}
+}
+
+func findVeth(name string, config Config) (Veth, bool) {
+	for _, v := range config.Veths {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Veth{}, false
+}
+
+func getVethJSONParam(req *http.Request) (Veth, error) {
+	req.ParseForm()
+	var v Veth
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &v); err != nil {
+		return Veth{}, errors.New("用户输入参数格式有误")
+	}
+	if v.Name == "" {
+		return Veth{}, errors.New("Veth's Name can not be empty")
+	}
+	return v, nil
+}
+
+func buildVeth(veths []Veth) error {
+	for _, v := range veths {
+		if err := addVeth(v); err != nil {
+			log.WithError(err).Error("add veth failed")
+			return err
+		}
+		if err := setLinkSettings(v.Name, v.Mtu, v.HardwareAddr, v.Alias); err != nil {
+			log.WithError(err).Error("veth set mtu, mac or alias failed")
+			return err
+		}
+		for _, ipNet := range v.IpNets {
+			if err := setIP(v.Name, ipNet); err != nil {
+				log.WithError(err).Error("veth add Ip failed")
+				return err
+			}
+		}
+		if !peerInHost(v) {
+			continue
+		}
+		for _, ipNet := range v.PeerIpNets {
+			if err := setIP(v.Peer, ipNet); err != nil {
+				log.WithError(err).Error("veth peer add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// the peer moved into another namespace gets its addresses and is brought up there,
+// nothing manages it afterwards
+func addVeth(v Veth) error {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: v.Name, MTU: v.Mtu}, PeerName: v.Peer}
+	ns, err := peerNetns(v)
+	if err != nil {
+		log.WithError(err).Error("Get the namespace of veth " + v.Name + "'s peer failed")
+		return err
+	}
+	if ns.IsOpen() {
+		defer ns.Close()
+		veth.PeerNamespace = netlink.NsFd(ns)
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		log.WithError(err).Error("Add veth " + v.Name + " fail ")
+		return err
+	}
+	if !ns.IsOpen() {
+		return nil
+	}
+
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		log.WithError(err).Error("Open the namespace of veth " + v.Name + "'s peer failed")
+		return err
+	}
+	defer handle.Close()
+	peer, err := handle.LinkByName(v.Peer)
+	if err != nil {
+		log.WithError(err).Error("Get peer link " + v.Peer + " failed")
+		return err
+	}
+	for _, ipNet := range v.PeerIpNets {
+		addr, err := netlink.ParseAddr(ipNet)
+		if err != nil {
+			log.WithError(err).Error("Parse IP " + ipNet + " failed")
+			return err
+		}
+		if err := handle.AddrAdd(peer, addr); err != nil {
+			log.WithError(err).Error("Add IP " + ipNet + " to peer " + v.Peer + " failed")
+			return err
+		}
+	}
+	if err := handle.LinkSetUp(peer); err != nil {
+		log.WithError(err).Error("Up peer " + v.Peer + " link failed")
+		return err
+	}
+	return nil
+}
+
+// netns.None() when the peer stays in the host namespace
+func peerNetns(v Veth) (netns.NsHandle, error) {
+	switch {
+	case v.PeerNetns != "":
+		return netns.GetFromName(v.PeerNetns)
+	case v.PeerPid != 0:
+		return netns.GetFromPid(v.PeerPid)
+	}
+	return netns.None(), nil
+}
+
+// a pair in the host namespace is one veth, listed by the end with the lower index with the addresses of the other end
+// as PeerIpNets; false for the other end, and for a veth whose peer is gone or in a namespace that is not found
+func vethOf(link *netlink.Veth) (Veth, bool, error) {
+	attrs := link.Attrs()
+	v := Veth{Index: attrs.Index, Name: attrs.Name}
+	if attrs.NetNsID < 0 {
+		peer, err := netlink.LinkByIndex(attrs.ParentIndex)
+		if err != nil || peer.Attrs().Index < attrs.Index {
+			return Veth{}, false, nil
+		}
+		v.Peer = peer.Attrs().Name
+		v.PeerIpNets, err = linkIpNets(netlink.AddrList, peer)
+		return v, err == nil, err
+	}
+
+	ns, ok := findPeerNetns(attrs.NetNsID, &v)
+	if !ok {
+		log.Warn("The namespace of veth " + attrs.Name + "'s peer is not found, the veth is not listed")
+		return Veth{}, false, nil
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		log.WithError(err).Error("Open the namespace of veth " + attrs.Name + "'s peer failed")
+		return Veth{}, false, err
+	}
+	defer handle.Close()
+	peer, err := handle.LinkByIndex(attrs.ParentIndex)
+	if err != nil {
+		log.WithError(err).Error("Get veth " + attrs.Name + "'s peer failed")
+		return Veth{}, false, err
+	}
+	v.Peer = peer.Attrs().Name
+	v.PeerIpNets, err = linkIpNets(handle.AddrList, peer)
+	return v, err == nil, err
+}
+
+func linkIpNets(addrList func(netlink.Link, int) ([]netlink.Addr, error), link netlink.Link) ([]string, error) {
+	addrs, err := addrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		log.WithError(err).Error("Get addresses of " + link.Attrs().Name + " failed")
+		return nil, err
+	}
+	var ipNets []string
+	for _, addr := range addrs {
+		ipNets = append(ipNets, addr.IPNet.String())
+	}
+	return ipNets, nil
+}
+
+// the namespace with the id is looked up in /var/run/netns first, then in the namespaces of the processes,
+// v gets PeerNetns or PeerPid of the one found
+func findPeerNetns(nsID int, v *Veth) (netns.NsHandle, bool) {
+	if files, err := ioutil.ReadDir("/var/run/netns"); err == nil {
+		for _, file := range files {
+			if ns, err := netns.GetFromName(file.Name()); err == nil {
+				if isNetns(ns, nsID) {
+					v.PeerNetns = file.Name()
+					return ns, true
+				}
+				ns.Close()
+			}
+		}
+	}
+	files, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		log.WithError(err).Error("List processes failed")
+		return netns.None(), false
+	}
+	for _, file := range files {
+		pid, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		if ns, err := netns.GetFromPid(pid); err == nil {
+			if isNetns(ns, nsID) {
+				v.PeerPid = pid
+				return ns, true
+			}
+			ns.Close()
+		}
+	}
+	return netns.None(), false
+}
+
+func isNetns(ns netns.NsHandle, nsID int) bool {
+	id, err := netlink.GetNetNsIdByFd(int(ns))
+	return err == nil && id == nsID
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVeth(t *testing.T) {
+	config := Config{
+		Devices: []Device{{Name: "eth0"}},
+		Veths: []Veth{
+			{Name: "veth0", Peer: "veth1", IpNets: []string{"10.0.0.1/24"}, PeerIpNets: []string{"10.0.0.2/24"}},
+			{Name: "veth2", Peer: "eth0", PeerNetns: "web", PeerIpNets: []string{"10.0.1.2/24"}},
+		},
+		Bridges: []Bridge{{Name: "br0", Devs: []string{"veth1", "veth2"}}},
+	}
+	// the peer moved away can take a name used in the host
+	assert.Nil(t, validateConfig(config))
+
+	config.Veths[1].PeerNetns = ""
+	assert.Equal(t, ErrNameUsed, validateConfig(config))
+	config.Veths[1].PeerPid = 1234
+	assert.Nil(t, validateConfig(config))
+	config.Veths[1].PeerNetns = "web"
+	assert.Equal(t, ErrVethNetns, validateConfig(config))
+	config.Veths[1].PeerPid = 0
+
+	config.Veths[1].PeerIpNets = []string{"10.0.1.2"}
+	assert.Error(t, validateConfig(config))
+	config.Veths[1].PeerIpNets = nil
+
+	config.Veths[1].Peer = ""
+	assert.Equal(t, ErrVethPeer, validateConfig(config))
+
+	assert.Error(t, validateVeth(Veth{Name: "veth0", Peer: "veth1", IpNets: []string{"10.0.0.1"}}))
+}
+
+func TestVethUpdate(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(Config{})
+	assert.Nil(t, VethAdd(Veth{Name: "veth0", Peer: "veth1", IpNets: []string{"10.0.0.1/24"}, PeerIpNets: []string{"10.0.0.2/24"}}))
+
+	assert.Error(t, VethUpdate(Veth{Name: "veth0", Peer: "veth1", PeerNetns: "web", PeerIpNets: []string{"10.0.0.2"}}))
+	assert.Equal(t, ErrVethNetns, VethUpdate(Veth{Name: "veth0", Peer: "veth1", PeerPid: -1}))
+	config, _ := GetConfigFromDs()
+	assert.Equal(t, []Veth{{Name: "veth0", Peer: "veth1", IpNets: []string{"10.0.0.1/24"}, PeerIpNets: []string{"10.0.0.2/24"}}}, config.Veths)
+
+	// the IPs are kept, they are changed by the IP API
+	assert.Nil(t, VethUpdate(Veth{Name: "veth0", Peer: "veth1", Mtu: 9000}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Veth{{Name: "veth0", Peer: "veth1", IpNets: []string{"10.0.0.1/24"}, PeerIpNets: []string{"10.0.0.2/24"}, Mtu: 9000}}, config.Veths)
+
+	// the peer moved away is set up with the given IPs
+	assert.Nil(t, VethUpdate(Veth{Name: "veth0", Peer: "veth1", PeerNetns: "web", PeerIpNets: []string{"10.0.0.3/24"}}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Veth{{Name: "veth0", Peer: "veth1", PeerNetns: "web", IpNets: []string{"10.0.0.1/24"}, PeerIpNets: []string{"10.0.0.3/24"}}}, config.Veths)
+}
+
+// a config shaped like the one listed from system: a pair once with the addresses of both ends,
+// the peer in another namespace with its namespace, and the bridge ports on either end
+func TestSysVethConfig(t *testing.T) {
+	config := Config{
+		Devices: []Device{{Index: 2, Name: "eth0", IpNets: []string{"192.168.1.10/24", "fe80::5054:ff:fe12:3456/64"}, Mtu: 1500, HardwareAddr: "52:54:00:12:34:56", State: UP}},
+		Bridges: []Bridge{{Index: 3, Name: "br0", Devs: []string{"veth1", "veth2"}, Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:01", State: UP}},
+		Veths: []Veth{
+			{Index: 4, Name: "veth1", Peer: "veth0", IpNets: []string{"fe80::1/64"}, PeerIpNets: []string{"10.0.0.1/24", "fe80::2/64"},
+				Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:02", State: UP},
+			{Index: 6, Name: "veth2", Peer: "eth0", PeerNetns: "web", PeerIpNets: []string{"10.0.1.2/24"}, Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:03", State: UP},
+			{Index: 7, Name: "veth3", Peer: "eth0", PeerPid: 1234, Mtu: 1500, HardwareAddr: "52:54:00:ab:cd:04", State: UP},
+		},
+	}
+	assert.Nil(t, validateConfig(config))
+	assert.Equal(t, []Drift{}, Diff(config, config))
+}
+
+func TestDiffVeth(t *testing.T) {
+	desired := Config{Veths: []Veth{
+		{Name: "veth0", Peer: "veth1", PeerIpNets: []string{"10.0.0.2/24"}, HardwareAddr: "52:54:00:12:34:56"},
+		{Name: "veth2", Peer: "eth0", PeerNetns: "web", PeerIpNets: []string{"10.0.1.2/24"}},
+	}}
+	// the system lists a pair by the end with the lower index, that may be the other end
+	live := Config{Veths: []Veth{
+		{Name: "veth1", Peer: "veth0", IpNets: []string{"10.0.0.2/24"}, State: UP},
+		{Name: "veth2", Peer: "eth0", PeerNetns: "web", State: UP},
+	}}
+	assert.Equal(t, []Drift{}, Diff(desired, live))
+
+	live.Veths[0] = Veth{Name: "veth0", Peer: "veth3", PeerIpNets: []string{"10.0.0.2/24"}, HardwareAddr: "52:54:00:12:34:57", State: UP}
+	assert.Equal(t, []Drift{
+		{Kind: LINK, Action: CHANGED, Type: VETH, Name: "veth0", Field: "Peer", Desired: "veth1", Live: "veth3"},
+		{Kind: LINK, Action: CHANGED, Type: VETH, Name: "veth0", Field: "HardwareAddr", Desired: "52:54:00:12:34:56", Live: "52:54:00:12:34:57"},
+		{Kind: LINK, Action: REMOVED, Type: VETH, Name: "veth1"},
+		{Kind: LINK, Action: ADDED, Type: VETH, Name: "veth3"},
+	}, Diff(desired, live))
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/vishvananda/netlink"
+)
+
+// the IANA port, the kernel uses 8472 when not given
+const VXLAN_PORT = 4789
+
+func vxlanList(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Vxlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		rm = ResponseMessage{Result: userConfig.Vxlans, Status: true, Message: "获取Vxlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vxlanGet(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	userConfig, err := getConfigBySource(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "获取Vxlan失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if x, ok := findVxlan(name, userConfig); !ok {
+		rm = ResponseMessage{Status: false, Message: "获取Vxlan失败." + ErrNotFound.Error(), Code: http.StatusNotFound}
+	} else {
+		rm = ResponseMessage{Result: x, Status: true, Message: "获取Vxlan成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vxlanAdd(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	x, err := getVxlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Vxlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := VxlanAdd(x); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Vxlan添加失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Vxlan", vxlanSettings(x)).Info("添加Vxlan")
+		configMutations.WithLabelValues(VXLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Vxlan添加成功", Code: http.StatusCreated}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vxlanUpdate(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	x, err := getVxlanJSONParam(req)
+	if err != nil {
+		rm = ResponseMessage{Status: false, Message: "Vxlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else if err := VxlanUpdate(x); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Vxlan更新失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.WithField("Vxlan", vxlanSettings(x)).Info("更新Vxlan")
+		configMutations.WithLabelValues(VXLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Vxlan更新成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func vxlanDel(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var rm ResponseMessage
+	resp.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("Name")
+	if err := VxlanDel(name); err != nil {
+		rm = ResponseMessage{Status: false, Message: "Vxlan删除失败." + err.Error(), Code: http.StatusInternalServerError}
+	} else {
+		log.Info("删除Vxlan:" + name)
+		configMutations.WithLabelValues(VXLAN).Inc()
+		rm = ResponseMessage{Status: true, Message: "Vxlan删除成功", Code: http.StatusOK}
+	}
+	ret, _ := json.MarshalIndent(rm, "", "\t")
+	resp.Write(ret)
+}
+
+func VxlanAdd(x Vxlan) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	if err := insertVxlan(vxlanSettings(x), &userConfig); err != nil {
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// the new vxlan is validated against the config without the old one, nothing is stored if it is invalid
+func VxlanUpdate(x Vxlan) error { // can not modify Name
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	// the IPs are set by the IP API, keep them
+	old, _ := findVxlan(x.Name, userConfig)
+	x = vxlanSettings(x)
+	x.IpNets = old.IpNets
+	removeVxlan(x.Name, &userConfig)
+	if err := insertVxlan(x, &userConfig); err != nil {
+		log.WithError(err).Error("Vxlan " + x.Name + " update fail")
+		return err
+	}
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+func VxlanDel(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	userConfig, err := GetConfigFromDs()
+	if err != nil {
+		log.WithError(err).Error("Get config from database failed")
+		return err
+	}
+
+	removeVxlan(name, &userConfig)
+
+	if err := PutToDataSource(userConfig); err != nil {
+		log.WithError(err).Error("Put data to database fail")
+		return err
+	}
+	return nil
+}
+
+// validate and add the vxlan to userConfig, shared by add and update
+func insertVxlan(x Vxlan, userConfig *Config) error {
+	if isLinkAlreadyExists(x.Name, *userConfig) {
+		log.WithError(ErrNameUsed).Error("Name:" + x.Name)
+		return ErrNameUsed
+	}
+	names := make(map[string]bool)
+	for _, name := range linkNames(*userConfig) {
+		names[name] = true
+	}
+	if err := validateVxlan(x, names); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+
+	userConfig.Vxlans = append(userConfig.Vxlans, x)
+	if err := validateLinkSettings(*userConfig); err != nil {
+		log.WithError(err).Error("Validate fail")
+		return err
+	}
+	return nil
+}
+
+func removeVxlan(name string, userConfig *Config) {
+	for i, x := range userConfig.Vxlans {
+		if x.Name == name {
+			userConfig.Vxlans = append(userConfig.Vxlans[:i], userConfig.Vxlans[i+1:]...)
+			break
+		}
+	}
+}
+
+// names are the interfaces in config, the underlay Dev should be one of them
+func validateVxlan(x Vxlan, names map[string]bool) error {
+	if x.Vni < 1 || x.Vni > 16777215 {
+		return ErrVxlanVni
+	}
+	if x.Dev != "" && !names[x.Dev] {
+		log.WithError(ErrDevsNull).Error("Dev:" + x.Dev)
+		return ErrDevsNull
+	}
+	for _, addr := range []string{x.Local, x.Remote, x.Group} {
+		if addr != "" && net.ParseIP(addr) == nil {
+			log.WithError(ErrVxlanAddr).Error("Vxlan:" + x.Name + " Addr:" + addr)
+			return ErrVxlanAddr
+		}
+	}
+	if x.Remote != "" && net.ParseIP(x.Remote).IsMulticast() {
+		return ErrVxlanAddr
+	}
+	// the kernel takes the family from the remote end, the local one must match it
+	for _, addr := range []string{x.Remote, x.Group} {
+		if x.Local != "" && addr != "" && (net.ParseIP(x.Local).To4() != nil) != (net.ParseIP(addr).To4() != nil) {
+			log.WithError(ErrVxlanAddr).Error("Vxlan:" + x.Name + " Local:" + x.Local + " Remote:" + addr)
+			return ErrVxlanAddr
+		}
+	}
+	if x.Group != "" && (x.Remote != "" || x.Dev == "" || !net.ParseIP(x.Group).IsMulticast()) {
+		return ErrVxlanGroup
+	}
+	if x.Port < 0 || x.Port > 65535 {
+		return ErrVxlanPort
+	}
+	if x.Learning != "" && x.Learning != "on" && x.Learning != "off" {
+		return ErrVxlanLearning
+	}
+	return validateIpNets(x.Name, x.IpNets)
+}
+
+func vxlanSettings(x Vxlan) Vxlan {
+	return Vxlan{Name: x.Name, Vni: x.Vni, Local: x.Local, Remote: x.Remote, Group: x.Group, Port: x.Port, Dev: x.Dev, Learning: x.Learning,
+		IpNets: x.IpNets, Mtu: x.Mtu, HardwareAddr: x.HardwareAddr, Alias: x.Alias, State: x.State}
+}
+
+func findVxlan(name string, config Config) (Vxlan, bool) {
+	for _, x := range config.Vxlans {
+		if x.Name == name {
+			return x, true
+		}
+	}
+	return Vxlan{}, false
+}
+
+func getVxlanJSONParam(req *http.Request) (Vxlan, error) {
+	req.ParseForm()
+	var x Vxlan
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1048576))
+	if err := json.Unmarshal(body, &x); err != nil {
+		return Vxlan{}, errors.New("用户输入参数格式有误")
+	}
+	if x.Name == "" {
+		return Vxlan{}, errors.New("Vxlan's Name can not be empty")
+	}
+	return x, nil
+}
+
+func buildVxlan(vxlans []Vxlan) error {
+	for _, x := range vxlans {
+		if err := addVxlan(x); err != nil {
+			log.WithError(err).Error("add vxlan failed")
+			return err
+		}
+		if err := setLinkSettings(x.Name, x.Mtu, x.HardwareAddr, x.Alias); err != nil {
+			log.WithError(err).Error("vxlan set mtu, mac or alias failed")
+			return err
+		}
+		for _, ipNet := range x.IpNets {
+			if err := setIP(x.Name, ipNet); err != nil {
+				log.WithError(err).Error("vxlan add Ip failed")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addVxlan(x Vxlan) error {
+	vxlan := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: x.Name}, VxlanId: x.Vni, Port: vxlanPort(x.Port), Learning: x.Learning != "off"}
+	if x.Dev != "" {
+		devIndex, err := getIndexByName(x.Dev)
+		if err != nil {
+			log.WithError(err).Error("get underlay device " + x.Dev + "'s index fail ")
+			return err
+		}
+		vxlan.VtepDevIndex = devIndex
+	}
+	if x.Local != "" {
+		vxlan.SrcAddr = net.ParseIP(x.Local)
+	}
+	// netlink takes the unicast remote as the group too
+	if x.Remote != "" {
+		vxlan.Group = net.ParseIP(x.Remote)
+	} else if x.Group != "" {
+		vxlan.Group = net.ParseIP(x.Group)
+	}
+
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		log.WithError(err).Error("Add vxlan " + x.Name + " fail ")
+		return err
+	}
+	return nil
+}
+
+func vxlanPort(port int) int {
+	if port == 0 {
+		return VXLAN_PORT
+	}
+	return port
+}
+
+// the Vxlan of link read back from system, without IpNets
+func vxlanOf(link *netlink.Vxlan) Vxlan {
+	x := Vxlan{Index: link.Index, Name: link.Name, Vni: link.VxlanId, Port: link.Port, Learning: "off"}
+	if link.Learning {
+		x.Learning = "on"
+	}
+	if link.VtepDevIndex != 0 {
+		if dev, err := netlink.LinkByIndex(link.VtepDevIndex); err == nil {
+			x.Dev = dev.Attrs().Name
+		}
+	}
+	if link.SrcAddr != nil && !link.SrcAddr.IsUnspecified() {
+		x.Local = link.SrcAddr.String()
+	}
+	if link.Group != nil && !link.Group.IsUnspecified() {
+		if link.Group.IsMulticast() {
+			x.Group = link.Group.String()
+		} else {
+			x.Remote = link.Group.String()
+		}
+	}
+	return x
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestValidateVxlan(t *testing.T) {
+	config := Config{
+		Devices: []Device{{Name: "eth0"}},
+		Vxlans:  []Vxlan{{Name: "vxlan100", Vni: 100, Local: "10.0.0.1", Remote: "10.0.0.2", Dev: "eth0"}},
+		Bridges: []Bridge{{Name: "br100", Devs: []string{"vxlan100"}}},
+	}
+	assert.Nil(t, validateConfig(config))
+
+	x := &config.Vxlans[0]
+	x.Vni = 16777216
+	assert.Equal(t, ErrVxlanVni, validateConfig(config))
+	x.Vni = 100
+
+	x.Dev = "eth9"
+	assert.Equal(t, ErrDevsNull, validateConfig(config))
+	x.Dev = "eth0"
+
+	x.Remote = "10.0.0"
+	assert.Equal(t, ErrVxlanAddr, validateConfig(config))
+	x.Remote = "239.1.1.1"
+	assert.Equal(t, ErrVxlanAddr, validateConfig(config))
+	x.Remote = "2001:db8::2"
+	assert.Equal(t, ErrVxlanAddr, validateConfig(config))
+	x.Local = "2001:db8::1"
+	assert.Nil(t, validateConfig(config))
+	x.Local = "10.0.0.1"
+	x.Remote = ""
+
+	x.Group = "10.0.0.2"
+	assert.Equal(t, ErrVxlanGroup, validateConfig(config))
+	x.Group = "239.1.1.1"
+	assert.Nil(t, validateConfig(config))
+	x.Group = "ff05::1"
+	assert.Equal(t, ErrVxlanAddr, validateConfig(config))
+	x.Group = "239.1.1.1"
+	x.Dev = ""
+	assert.Equal(t, ErrVxlanGroup, validateConfig(config))
+	x.Dev = "eth0"
+
+	x.Port = 65536
+	assert.Equal(t, ErrVxlanPort, validateConfig(config))
+	x.Port = 0
+
+	x.Learning = "yes"
+	assert.Equal(t, ErrVxlanLearning, validateConfig(config))
+	x.Learning = ""
+
+	x.IpNets = []string{"10.1.0.1"}
+	assert.Error(t, validateVxlan(*x, map[string]bool{"eth0": true}))
+}
+
+func TestVxlanUpdate(t *testing.T) {
+	old, _ := GetConfigFromDs()
+	defer PutToDataSource(old)
+	PutToDataSource(Config{Devices: []Device{{Name: "eth0"}}})
+	assert.Nil(t, VxlanAdd(Vxlan{Name: "vxlan100", Vni: 100, Remote: "10.0.0.2", IpNets: []string{"192.168.100.1/24"}}))
+
+	assert.Equal(t, ErrVxlanVni, VxlanUpdate(Vxlan{Name: "vxlan100", Remote: "10.0.0.2"}))
+	config, _ := GetConfigFromDs()
+	assert.Equal(t, []Vxlan{{Name: "vxlan100", Vni: 100, Remote: "10.0.0.2", IpNets: []string{"192.168.100.1/24"}}}, config.Vxlans)
+
+	// the IPs are kept, they are changed by the IP API
+	assert.Nil(t, VxlanUpdate(Vxlan{Name: "vxlan100", Vni: 200, Remote: "10.0.0.3"}))
+	config, _ = GetConfigFromDs()
+	assert.Equal(t, []Vxlan{{Name: "vxlan100", Vni: 200, Remote: "10.0.0.3", IpNets: []string{"192.168.100.1/24"}}}, config.Vxlans)
+}
+
+func TestVxlanOf(t *testing.T) {
+	link := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Index: 5, Name: "vxlan100"}, VxlanId: 100, SrcAddr: net.ParseIP("10.0.0.1"),
+		Group: net.ParseIP("10.0.0.2"), Port: 4789}
+	assert.Equal(t, Vxlan{Index: 5, Name: "vxlan100", Vni: 100, Local: "10.0.0.1", Remote: "10.0.0.2", Port: 4789, Learning: "off"}, vxlanOf(link))
+
+	link.Group, link.SrcAddr, link.Learning = net.ParseIP("239.1.1.1"), net.IPv4zero, true
+	assert.Equal(t, Vxlan{Index: 5, Name: "vxlan100", Vni: 100, Group: "239.1.1.1", Port: 4789, Learning: "on"}, vxlanOf(link))
+}